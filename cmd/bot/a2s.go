@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// a2sQuery asks servers speaking Valve's server query protocol, used by
+// Source engine games and many others, for their info and players over UDP.
+// https://developer.valvesoftware.com/wiki/Server_queries
+type a2sQuery struct{}
+
+// Packet headers and message types of the A2S protocol.
+const (
+	a2sSinglePacket = -1
+	a2sSplitPacket  = -2
+
+	a2sInfoRequest    = 'T'
+	a2sInfoResponse   = 'I'
+	a2sPlayerRequest  = 'U'
+	a2sPlayerResponse = 'D'
+	a2sChallenge      = 'A'
+)
+
+// Servers split responses into packets of at most this size.
+const a2sMaxPacketSize = 1400
+
+// How many challenges a server may answer a request with before giving up.
+const a2sMaxChallenges = 3
+
+var a2sInfoPayload = []byte("Source Engine Query\x00")
+
+func (a2sQuery) Query(ctx context.Context, addr string) (ServerInfo, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	r, err := a2sRequest(conn, a2sInfoRequest, a2sInfoPayload, nil, a2sInfoResponse)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	info, err := parseA2SInfo(r)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+
+	// Player names are a nicety, some servers don't answer A2S_PLAYER at all.
+	r, err = a2sRequest(conn, a2sPlayerRequest, nil, []byte{0xFF, 0xFF, 0xFF, 0xFF}, a2sPlayerResponse)
+	if err == nil {
+		info.PlayerNames, _ = parseA2SPlayers(r)
+	}
+	return info, nil
+}
+
+// a2sRequest sends a request of type kind and returns the reader of the
+// response of type want. When the server answers with a challenge, the request
+// is sent again with payload followed by the challenge.
+func a2sRequest(conn net.Conn, kind byte, payload []byte, challenge []byte, want byte) (*a2sReader, error) {
+	for n := 0; n <= a2sMaxChallenges; n++ {
+		req := []byte{0xFF, 0xFF, 0xFF, 0xFF, kind}
+		req = append(req, payload...)
+		req = append(req, challenge...)
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := a2sReadResponse(conn)
+		if err != nil {
+			return nil, err
+		}
+		r := &a2sReader{buf: resp}
+		switch t := r.byte(); t {
+		case want:
+			return r, r.err
+		case a2sChallenge:
+			challenge = r.bytes(4)
+			if r.err != nil {
+				return nil, r.err
+			}
+		default:
+			return nil, fmt.Errorf("a2s: unexpected response type %q", t)
+		}
+	}
+	return nil, errors.New("a2s: server kept sending challenges")
+}
+
+// a2sReadResponse reads a response, reassembling it if the server split it
+// into several packets, and returns it without its header.
+func a2sReadResponse(conn net.Conn) ([]byte, error) {
+	buf := make([]byte, a2sMaxPacketSize+64)
+	var (
+		id    uint32
+		parts [][]byte
+		seen  int
+	)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		r := &a2sReader{buf: buf[:n]}
+		switch header := r.int32(); {
+		case r.err != nil:
+			return nil, r.err
+		case header == a2sSinglePacket:
+			if parts != nil {
+				return nil, errors.New("a2s: single packet in the middle of a split response")
+			}
+			return r.rest(), nil
+		case header != a2sSplitPacket:
+			return nil, fmt.Errorf("a2s: unknown packet header %#x", uint32(header))
+		}
+
+		// Source engine split packet, GoldSrc servers lay them out differently.
+		packetID := uint32(r.int32())
+		total := int(r.byte())
+		number := int(r.byte())
+		r.int16() // maximum packet size
+		switch {
+		case r.err != nil:
+			return nil, r.err
+		case packetID&0x80000000 != 0:
+			return nil, errors.New("a2s: compressed responses are not supported")
+		case total == 0 || number >= total:
+			return nil, fmt.Errorf("a2s: invalid split packet %d of %d", number, total)
+		}
+		if parts == nil {
+			id = packetID
+			parts = make([][]byte, total)
+		}
+		if packetID != id || total != len(parts) {
+			return nil, errors.New("a2s: split packet of another response")
+		}
+		if parts[number] == nil {
+			parts[number] = append([]byte{}, r.rest()...)
+			seen++
+		}
+		if seen < total {
+			continue
+		}
+
+		r = &a2sReader{buf: bytes.Join(parts, nil)}
+		if header := r.int32(); r.err != nil || header != a2sSinglePacket {
+			return nil, errors.New("a2s: invalid split response")
+		}
+		return r.rest(), nil
+	}
+}
+
+func parseA2SInfo(r *a2sReader) (ServerInfo, error) {
+	var info ServerInfo
+	r.byte() // protocol
+	info.Name = r.string()
+	info.Map = r.string()
+	r.string() // folder
+	r.string() // game
+	r.int16()  // steam app ID
+	players := int(r.byte())
+	info.MaxPlayers = int(r.byte())
+	bots := int(r.byte())
+	if r.err != nil {
+		return ServerInfo{}, fmt.Errorf("a2s: invalid info response: %w", r.err)
+	}
+	if players > bots {
+		info.Players = players - bots
+	}
+	return info, nil
+}
+
+func parseA2SPlayers(r *a2sReader) ([]string, error) {
+	count := int(r.byte())
+	var names []string
+	for n := 0; n < count && r.err == nil; n++ {
+		r.byte() // index
+		name := r.string()
+		r.int32()   // score
+		r.float32() // seconds connected
+		// Players still connecting have no name yet.
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("a2s: invalid player response: %w", r.err)
+	}
+	return names, nil
+}
+
+// a2sReader reads the little endian fields of an A2S response. After the
+// first read past the end, every read returns zero and err is set.
+type a2sReader struct {
+	buf []byte
+	err error
+}
+
+var errA2SShort = errors.New("response too short")
+
+func (r *a2sReader) bytes(n int) []byte {
+	if r.err != nil || len(r.buf) < n {
+		r.err = errA2SShort
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *a2sReader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *a2sReader) int16() int16 {
+	if b := r.bytes(2); b != nil {
+		return int16(binary.LittleEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *a2sReader) int32() int32 {
+	if b := r.bytes(4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *a2sReader) float32() float32 {
+	if b := r.bytes(4); b != nil {
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+// string reads a null terminated string.
+func (r *a2sReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.buf, 0)
+	if end < 0 {
+		r.err = errA2SShort
+		return ""
+	}
+	s := string(r.buf[:end])
+	r.buf = r.buf[end+1:]
+	return s
+}
+
+func (r *a2sReader) rest() []byte {
+	b := r.buf
+	r.buf = nil
+	return b
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testChallenge = []byte{0x12, 0x34, 0x56, 0x78}
+
+// serveA2S answers each request received on a local UDP socket with the
+// packets reply returns for it, and returns the address to query.
+func serveA2S(t *testing.T, reply func(req []byte) [][]byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, a2sMaxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, packet := range reply(append([]byte{}, buf[:n]...)) {
+				conn.WriteTo(packet, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// a2sPacket is a single packet response holding body.
+func a2sPacket(body ...[]byte) []byte {
+	return append([]byte{0xFF, 0xFF, 0xFF, 0xFF}, bytes.Join(body, nil)...)
+}
+
+// a2sSplit splits the single packet response packet into n packets.
+func a2sSplit(packet []byte, n int) [][]byte {
+	size := (len(packet) + n - 1) / n
+	var packets [][]byte
+	for number := 0; number < n; number++ {
+		end := (number + 1) * size
+		if end > len(packet) {
+			end = len(packet)
+		}
+		header := []byte{0xFE, 0xFF, 0xFF, 0xFF, 0x01, 0x00, 0x00, 0x00, byte(n), byte(number), 0x00, 0x00}
+		binary.LittleEndian.PutUint16(header[10:], a2sMaxPacketSize)
+		packets = append(packets, append(header, packet[number*size:end]...))
+	}
+	return packets
+}
+
+func a2sInfoBody(name string, players byte, maxPlayers byte, bots byte) []byte {
+	body := []byte{a2sInfoResponse, 17}
+	for _, s := range []string{name, "Dedicated", "valheim", "Valheim"} {
+		body = append(body, s...)
+		body = append(body, 0)
+	}
+	body = append(body, 0xA6, 0x7D) // steam app ID
+	return append(body, players, maxPlayers, bots)
+}
+
+func a2sPlayersBody(names ...string) []byte {
+	body := []byte{a2sPlayerResponse, byte(len(names))}
+	for n, name := range names {
+		body = append(body, byte(n))
+		body = append(body, name...)
+		body = append(body, 0)
+		body = append(body, 0, 0, 0, 0, 0, 0, 0x80, 0x3F) // score, seconds connected
+	}
+	return body
+}
+
+// withChallenge answers requests without the test challenge with it.
+func withChallenge(req []byte, packets ...[]byte) [][]byte {
+	if !bytes.HasSuffix(req, testChallenge) {
+		return [][]byte{a2sPacket([]byte{a2sChallenge}, testChallenge)}
+	}
+	return packets
+}
+
+func queryA2S(t *testing.T, addr string, timeout time.Duration) (ServerInfo, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return a2sQuery{}.Query(ctx, addr)
+}
+
+func TestA2SQuery(t *testing.T) {
+	addr := serveA2S(t, func(req []byte) [][]byte {
+		switch req[4] {
+		case a2sInfoRequest:
+			if !bytes.HasPrefix(req[5:], a2sInfoPayload) {
+				t.Errorf("info request = %q, want the payload", req)
+			}
+			return withChallenge(req, a2sPacket(a2sInfoBody("Vikings", 5, 10, 1)))
+		case a2sPlayerRequest:
+			players := a2sSplit(a2sPacket(a2sPlayersBody("Ragnar", "", strings.Repeat("L", 1500))), 3)
+			// Split packets may arrive in any order.
+			return withChallenge(req, players[2], players[0], players[1])
+		}
+		return nil
+	})
+
+	info, err := queryA2S(t, addr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ServerInfo{
+		Name:        "Vikings",
+		Map:         "Dedicated",
+		Players:     4,
+		MaxPlayers:  10,
+		PlayerNames: []string{"Ragnar", strings.Repeat("L", 1500)},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("Query() = %+v, want %+v", info, want)
+	}
+}
+
+func TestA2SQueryWithoutPlayers(t *testing.T) {
+	// Servers that ignore A2S_PLAYER still report their info.
+	addr := serveA2S(t, func(req []byte) [][]byte {
+		if req[4] == a2sInfoRequest {
+			return [][]byte{a2sPacket(a2sInfoBody("Vikings", 0, 10, 0))}
+		}
+		return nil
+	})
+
+	info, err := queryA2S(t, addr, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Vikings" || info.PlayerNames != nil {
+		t.Errorf("Query() = %+v, want the info without players", info)
+	}
+}
+
+func TestA2SQueryInvalid(t *testing.T) {
+	info := a2sPacket(a2sInfoBody("Vikings", 1, 10, 0))
+	split := a2sSplit(info, 2)
+	compressed := append([]byte{}, split[0]...)
+	compressed[7] |= 0x80
+	tests := []struct {
+		name    string
+		packets [][]byte
+		want    string
+	}{
+		{name: "truncated info", packets: [][]byte{info[:len(info)-2]}, want: "invalid info response"},
+		{name: "name without terminator", packets: [][]byte{a2sPacket([]byte{a2sInfoResponse, 17}, []byte("Vikings"))}, want: "invalid info response"},
+		{name: "truncated header", packets: [][]byte{{0xFF, 0xFF}}, want: "too short"},
+		{name: "unknown header", packets: [][]byte{{0x01, 0x02, 0x03, 0x04, a2sInfoResponse}}, want: "unknown packet header"},
+		{name: "unexpected type", packets: [][]byte{a2sPacket([]byte{a2sPlayerResponse, 0})}, want: "unexpected response type"},
+		{name: "truncated challenge", packets: [][]byte{a2sPacket([]byte{a2sChallenge, 0x12})}, want: "too short"},
+		{name: "compressed", packets: [][]byte{compressed}, want: "compressed"},
+		{name: "split number out of range", packets: [][]byte{append(append([]byte{}, split[0][:9]...), append([]byte{2}, split[0][10:]...)...)}, want: "invalid split packet"},
+		{name: "single packet inside split", packets: [][]byte{split[0], info}, want: "single packet in the middle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveA2S(t, func(req []byte) [][]byte {
+				return tt.packets
+			})
+			_, err := queryA2S(t, addr, time.Second)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Query() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestA2SQueryEndlessChallenges(t *testing.T) {
+	var requests int
+	addr := serveA2S(t, func(req []byte) [][]byte {
+		requests++
+		return [][]byte{a2sPacket([]byte{a2sChallenge}, []byte{byte(requests), 0, 0, 0})}
+	})
+
+	_, err := queryA2S(t, addr, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "kept sending challenges") {
+		t.Errorf("Query() error = %v, want it to give up", err)
+	}
+}
+
+func TestA2SQueryTimeout(t *testing.T) {
+	addr := serveA2S(t, func(req []byte) [][]byte {
+		return nil
+	})
+
+	if _, err := queryA2S(t, addr, 100*time.Millisecond); err == nil {
+		t.Error("Query() of a silent server succeeded")
+	}
+}
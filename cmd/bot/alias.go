@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// resolveInstance turns the server option, which may be an instance ID, a guild
+// alias or the instance Name tag, into an instance ID.
+func resolveInstance(p ServerProvider, args map[string]string) (string, error) {
+	server := strings.TrimSpace(args["server"])
+	if server == "" {
+		return "", fmt.Errorf("a server must be specified")
+	}
+
+	aliases, err := queryDB("guild_aliases", map[string]interface{}{
+		"guild_id": args["guild_id"],
+		"region":   args["region"],
+		"alias":    strings.ToLower(server),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, a := range aliases {
+		return a["instance_id"].(string), nil
+	}
+
+	instances, err := p.List(context.TODO())
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []Instance
+	for _, instance := range instances {
+		if instance.ID == server {
+			return instance.ID, nil
+		}
+		if strings.EqualFold(instance.Name, server) {
+			candidates = append(candidates, instance)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0 && strings.HasPrefix(server, "i-"):
+		// Instances outside the allowlist are hidden, the ID may well exist.
+		return "", fmt.Errorf("instance `%s` is not managed in `%s`, an admin can add it with `/servers add`", server, args["region"])
+	case len(candidates) == 0:
+		return "", fmt.Errorf("no server named `%s` in `%s`", server, args["region"])
+	case len(candidates) == 1:
+		return candidates[0].ID, nil
+	}
+
+	names := make([]string, len(candidates))
+	for n, c := range candidates {
+		names[n] = fmt.Sprintf("`%s` (%s)", c.ID, strings.ToUpper(c.State))
+	}
+	return "", fmt.Errorf("`%s` matches %d servers, use one of: %s", server, len(candidates), strings.Join(names, ", "))
+}
+
+func getAliases(guildID string, region string) ([]map[string]interface{}, error) {
+	aliases, err := queryDB("guild_aliases", map[string]interface{}{
+		"guild_id": guildID,
+		"region":   region,
+	})
+	sort.Slice(aliases, func(a, b int) bool {
+		return aliases[a]["alias"].(string) < aliases[b]["alias"].(string)
+	})
+	return aliases, err
+}
+
+// aliasSubcommands implements /alias add|remove|list.
+var aliasSubcommands = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string){
+	"add": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		provider, err := getProvider(args)
+		var instanceID string
+		if err == nil {
+			instanceID, err = resolveInstance(provider, args)
+		}
+		auditInstance(i, instanceID)
+		if err == nil {
+			err = insertDB("guild_aliases", map[string]interface{}{
+				"guild_id":    args["guild_id"],
+				"region":      args["region"],
+				"alias":       strings.ToLower(args["alias"]),
+				"instance_id": instanceID,
+			})
+		}
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Alias `%s` now points to `%s` in `%s`", strings.ToLower(args["alias"]), instanceID, args["region"]))
+	},
+	"remove": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		n, err := deleteDB("guild_aliases", map[string]interface{}{
+			"guild_id": args["guild_id"],
+			"region":   args["region"],
+			"alias":    strings.ToLower(args["alias"]),
+		})
+		switch {
+		case err != nil:
+			sendError(s, i, err)
+		case n == 0:
+			sendMessageEphemeral(s, i, fmt.Sprintf("No alias `%s` in `%s`", strings.ToLower(args["alias"]), args["region"]))
+		default:
+			sendMessageEphemeral(s, i, fmt.Sprintf("Removed alias `%s` in `%s`", strings.ToLower(args["alias"]), args["region"]))
+		}
+	},
+	"list": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		aliases, err := getAliases(args["guild_id"], args["region"])
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		if len(aliases) == 0 {
+			sendMessageEphemeral(s, i, fmt.Sprintf("No aliases in `%s`, add one with `/alias add`", args["region"]))
+			return
+		}
+		var lines []string
+		for _, a := range aliases {
+			lines = append(lines, fmt.Sprintf("`%s` → `%s`", a["alias"], a["instance_id"]))
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Aliases in `%s`:\n%s", args["region"], strings.Join(lines, "\n")))
+	},
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// serverRule allows a guild to see and control an instance, either by ID or
+// by a "key=value" tag.
+type serverRule struct {
+	Kind  string
+	Value string
+}
+
+const (
+	serverRuleID  = "id"
+	serverRuleTag = "tag"
+)
+
+func (r serverRule) matches(instance Instance) bool {
+	switch r.Kind {
+	case serverRuleID:
+		return instance.ID == r.Value
+	case serverRuleTag:
+		key, value, hasValue := strings.Cut(r.Value, "=")
+		v, ok := instance.Tags[key]
+		return ok && (!hasValue || v == value)
+	}
+	return false
+}
+
+func (r serverRule) String() string {
+	return fmt.Sprintf("%s `%s`", r.Kind, r.Value)
+}
+
+func getServerRules(guildID string, region string) ([]serverRule, error) {
+	data, err := queryDB("guild_servers", map[string]interface{}{
+		"guild_id": guildID,
+		"region":   region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]serverRule, 0, len(data))
+	for _, d := range data {
+		rules = append(rules, serverRule{Kind: d["kind"].(string), Value: d["value"].(string)})
+	}
+	sort.Slice(rules, func(a, b int) bool {
+		if rules[a].Kind != rules[b].Kind {
+			return rules[a].Kind < rules[b].Kind
+		}
+		return rules[a].Value < rules[b].Value
+	})
+	return rules, nil
+}
+
+// allowlistProvider wraps a ServerProvider so that only instances matching one
+// of the guild's rules are listed or can be acted on.
+type allowlistProvider struct {
+	ServerProvider
+	region string
+	rules  []serverRule
+}
+
+func newAllowlistProvider(p ServerProvider, region string, rules []serverRule) ServerProvider {
+	return &allowlistProvider{ServerProvider: p, region: region, rules: rules}
+}
+
+func (p *allowlistProvider) allowed(instance Instance) bool {
+	for _, r := range p.rules {
+		if r.matches(instance) {
+			return true
+		}
+	}
+	return false
+}
+
+// check fails unless the instance is allowed for the guild.
+func (p *allowlistProvider) check(ctx context.Context, id string) (Instance, error) {
+	if len(p.rules) == 0 {
+		return Instance{}, p.errNoServers()
+	}
+	instance, err := p.ServerProvider.Describe(ctx, id)
+	if err != nil {
+		return Instance{}, err
+	}
+	if !p.allowed(instance) {
+		return Instance{}, fmt.Errorf("instance `%s` is not managed by this server, an admin can add it with `/servers add`", id)
+	}
+	return instance, nil
+}
+
+func (p *allowlistProvider) errNoServers() error {
+	return fmt.Errorf("no servers are registered in `%s`, an admin can add them with `/servers add`", p.region)
+}
+
+func (p *allowlistProvider) List(ctx context.Context) ([]Instance, error) {
+	if len(p.rules) == 0 {
+		return nil, p.errNoServers()
+	}
+	instances, err := p.ServerProvider.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []Instance
+	for _, instance := range instances {
+		if p.allowed(instance) {
+			allowed = append(allowed, instance)
+		}
+	}
+	return allowed, nil
+}
+
+func (p *allowlistProvider) Describe(ctx context.Context, id string) (Instance, error) {
+	return p.check(ctx, id)
+}
+
+func (p *allowlistProvider) Start(ctx context.Context, id string) (StateChange, error) {
+	if _, err := p.check(ctx, id); err != nil {
+		return StateChange{}, err
+	}
+	return p.ServerProvider.Start(ctx, id)
+}
+
+func (p *allowlistProvider) Stop(ctx context.Context, id string, force bool) (StateChange, error) {
+	if _, err := p.check(ctx, id); err != nil {
+		return StateChange{}, err
+	}
+	return p.ServerProvider.Stop(ctx, id, force)
+}
+
+func (p *allowlistProvider) Hibernate(ctx context.Context, id string) (StateChange, error) {
+	if _, err := p.check(ctx, id); err != nil {
+		return StateChange{}, err
+	}
+	return p.ServerProvider.Hibernate(ctx, id)
+}
+
+func (p *allowlistProvider) Reboot(ctx context.Context, id string) (StateChange, error) {
+	if _, err := p.check(ctx, id); err != nil {
+		return StateChange{}, err
+	}
+	return p.ServerProvider.Reboot(ctx, id)
+}
+
+// getServerRuleFromArgs builds the rule described by the /servers add and
+// remove options, resolving server names to instance IDs.
+func getServerRuleFromArgs(args map[string]string) (serverRule, error) {
+	switch {
+	case args["server"] != "" && args["tag"] != "":
+		return serverRule{}, fmt.Errorf("specify either a server or a tag, not both")
+	case args["tag"] != "":
+		return serverRule{Kind: serverRuleTag, Value: args["tag"]}, nil
+	case args["server"] != "":
+		provider, err := getUnrestrictedProvider(args)
+		if err != nil {
+			return serverRule{}, err
+		}
+		instanceID, err := resolveInstance(provider, args)
+		if err != nil {
+			return serverRule{}, err
+		}
+		return serverRule{Kind: serverRuleID, Value: instanceID}, nil
+	}
+	return serverRule{}, fmt.Errorf("specify a server or a tag")
+}
+
+// serversSubcommands implements /servers add|remove|list.
+var serversSubcommands = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string){
+	"add": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		rule, err := getServerRuleFromArgs(args)
+		if rule.Kind == serverRuleID {
+			auditInstance(i, rule.Value)
+		}
+		if err == nil {
+			err = insertDB("guild_servers", map[string]interface{}{
+				"guild_id": args["guild_id"],
+				"region":   args["region"],
+				"kind":     rule.Kind,
+				"value":    rule.Value,
+			})
+		}
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		autocompleteCache.invalidate(args["guild_id"], args["region"])
+		sendMessageEphemeral(s, i, fmt.Sprintf("Added %s to the managed servers in `%s`", rule, args["region"]))
+	},
+	"remove": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		rule, err := getServerRuleFromArgs(args)
+		if rule.Kind == serverRuleID {
+			auditInstance(i, rule.Value)
+		}
+		var n int64
+		if err == nil {
+			n, err = deleteDB("guild_servers", map[string]interface{}{
+				"guild_id": args["guild_id"],
+				"region":   args["region"],
+				"kind":     rule.Kind,
+				"value":    rule.Value,
+			})
+		}
+		switch {
+		case err != nil:
+			sendError(s, i, err)
+		case n == 0:
+			sendMessageEphemeral(s, i, fmt.Sprintf("%s is not a managed server in `%s`", rule, args["region"]))
+		default:
+			autocompleteCache.invalidate(args["guild_id"], args["region"])
+			sendMessageEphemeral(s, i, fmt.Sprintf("Removed %s from the managed servers in `%s`", rule, args["region"]))
+		}
+	},
+	"list": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		rules, err := getServerRules(args["guild_id"], args["region"])
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		if len(rules) == 0 {
+			sendMessageEphemeral(s, i, fmt.Sprintf("No managed servers in `%s`, add them with `/servers add`", args["region"]))
+			return
+		}
+		var lines []string
+		for _, r := range rules {
+			lines = append(lines, r.String())
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Managed servers in `%s`:\n%s", args["region"], strings.Join(lines, "\n")))
+	},
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Outcomes of an audited interaction.
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+	outcomeDenied  = "denied"
+)
+
+var outcomeList = [...]string{outcomeSuccess, outcomeFailure, outcomeDenied}
+
+func getOutcomeChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+
+	for _, o := range outcomeList {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  o,
+			Value: o,
+		})
+	}
+	return choices
+}
+
+// auditEvent records who used which command on which server, and how it went.
+type auditEvent struct {
+	GuildID    string
+	UserID     string
+	Command    string
+	Region     string
+	InstanceID string
+	Outcome    string
+	Error      string
+	CreatedAt  time.Time
+}
+
+// pendingAudits holds the event of every interaction being handled, keyed by
+// interaction ID, so handlers can add to it without passing it around.
+var pendingAudits sync.Map
+
+func isRegion(region string) bool {
+	for _, r := range regionList {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// startAudit begins the event of interaction i. Autocomplete runs on every
+// keystroke and changes nothing, so it is not audited.
+func startAudit(i *discordgo.InteractionCreate) {
+	e := &auditEvent{GuildID: i.GuildID, Outcome: outcomeSuccess}
+	if i.Member != nil {
+		e.UserID = i.Member.User.ID
+	} else if i.User != nil {
+		e.UserID = i.User.ID
+	}
+
+	var customID string
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		optionsMap := getOptionsMap(i)
+		e.Command = i.ApplicationCommandData().Name
+		if sub, ok := optionsMap["subcommand"].(string); ok {
+			e.Command += " " + sub
+		}
+		e.Region, _ = optionsMap["region"].(string)
+		// The server option may be a name or an alias, handlers record the
+		// instance once it is resolved, see auditInstance.
+	case discordgo.InteractionMessageComponent:
+		data := i.MessageComponentData()
+		customID = data.CustomID
+		if len(data.Values) > 0 && isRegion(data.Values[0]) {
+			e.Region = data.Values[0]
+		}
+	case discordgo.InteractionModalSubmit:
+		customID = i.ModalSubmitData().CustomID
+	default:
+		return
+	}
+	if customID != "" {
+		e.Command = getCustomIDName(customID)
+		if args := getCustomIDArgs(customID); len(args) > 0 && isRegion(args[0]) {
+			e.Region = args[0]
+		}
+	}
+	pendingAudits.Store(i.ID, e)
+}
+
+func getAudit(i *discordgo.InteractionCreate) *auditEvent {
+	if e, ok := pendingAudits.Load(i.ID); ok {
+		return e.(*auditEvent)
+	}
+	return nil
+}
+
+// auditFailure marks the interaction as failed with err.
+func auditFailure(i *discordgo.InteractionCreate, err error) {
+	if e := getAudit(i); e != nil {
+		e.Outcome = outcomeFailure
+		e.Error = err.Error()
+	}
+}
+
+// auditDenied marks the interaction as refused for lack of permission.
+func auditDenied(i *discordgo.InteractionCreate) {
+	if e := getAudit(i); e != nil {
+		e.Outcome = outcomeDenied
+	}
+}
+
+// auditInstance records the instance the interaction resolved to.
+func auditInstance(i *discordgo.InteractionCreate, instanceID string) {
+	if e := getAudit(i); e != nil && instanceID != "" {
+		e.InstanceID = instanceID
+	}
+}
+
+// finishAudit saves the event of interaction i and mirrors it into the
+// guild's audit channel, if one is set.
+func finishAudit(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	v, ok := pendingAudits.LoadAndDelete(i.ID)
+	if !ok {
+		return
+	}
+	saveAudit(s, v.(*auditEvent))
+}
+
+// saveAudit saves e and mirrors it into the guild's audit channel, if one is set.
+// Events not caused by an interaction, like automatic shutdowns, are saved
+// with it directly.
+func saveAudit(s *discordgo.Session, e *auditEvent) {
+	e.CreatedAt = time.Now().UTC()
+
+	err := insertDB("audit_events", map[string]interface{}{
+		"guild_id":    e.GuildID,
+		"user_id":     e.UserID,
+		"command":     e.Command,
+		"region":      nullIfEmpty(e.Region),
+		"instance_id": nullIfEmpty(e.InstanceID),
+		"outcome":     e.Outcome,
+		"error":       nullIfEmpty(e.Error),
+		"created_at":  e.CreatedAt,
+	})
+	if err != nil {
+		log.Println("Error saving audit event:", err)
+	}
+
+	channelID, err := getAuditChannel(e.GuildID)
+	if err != nil {
+		log.Println("Error reading audit channel:", err)
+		return
+	}
+	if channelID == "" {
+		return
+	}
+	_, err = s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content: formatAuditEvent(*e),
+		// Don't ping the members the event mentions.
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		log.Println("Error mirroring audit event:", err)
+	}
+}
+
+func newAuditEventFromRow(row map[string]interface{}) auditEvent {
+	createdAt, _ := time.Parse(time.RFC3339Nano, row["created_at"].(string))
+	return auditEvent{
+		GuildID:    row["guild_id"].(string),
+		UserID:     row["user_id"].(string),
+		Command:    row["command"].(string),
+		Region:     row["region"].(string),
+		InstanceID: row["instance_id"].(string),
+		Outcome:    row["outcome"].(string),
+		Error:      row["error"].(string),
+		CreatedAt:  createdAt,
+	}
+}
+
+// How much of an error an audit line shows.
+const maxAuditErrorLength = 200
+
+func formatAuditEvent(e auditEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<t:%d:f> <@%s> `/%s`", e.CreatedAt.Unix(), e.UserID, e.Command)
+	if e.Region != "" {
+		fmt.Fprintf(&b, " in `%s`", e.Region)
+	}
+	if e.InstanceID != "" {
+		fmt.Fprintf(&b, " on `%s`", e.InstanceID)
+	}
+	switch e.Outcome {
+	case outcomeSuccess:
+		b.WriteString(" ✅")
+	case outcomeDenied:
+		b.WriteString(" 🚫 denied")
+	default:
+		b.WriteString(" ❌")
+		if e.Error != "" {
+			msg := e.Error
+			if r := []rune(msg); len(r) > maxAuditErrorLength {
+				msg = string(r[:maxAuditErrorLength]) + "…"
+			}
+			fmt.Fprintf(&b, " `%s`", strings.ReplaceAll(msg, "`", "'"))
+		}
+	}
+	return b.String()
+}
+
+// getAuditChannel returns the channel audit events of the guild are mirrored into.
+func getAuditChannel(guildID string) (string, error) {
+	settings, err := queryDB("guild_settings", map[string]interface{}{
+		"guild_id": guildID,
+	})
+	if err != nil || len(settings) == 0 {
+		return "", err
+	}
+	return settings[0]["audit_channel_id"].(string), nil
+}
+
+// setAuditChannel mirrors audit events of the guild into channelID, or stops
+// mirroring them when it is empty.
+func setAuditChannel(guildID string, channelID string) error {
+	ctx := context.TODO()
+	return store.Tx(ctx, func(tx GuildStore) error {
+		where := map[string]interface{}{"guild_id": guildID}
+		values := map[string]interface{}{"audit_channel_id": nullIfEmpty(channelID)}
+		settings, err := tx.Query(ctx, "guild_settings", where)
+		if err != nil {
+			return err
+		}
+		if len(settings) == 0 {
+			values["guild_id"] = guildID
+			return tx.Insert(ctx, "guild_settings", values)
+		}
+		_, err = tx.Update(ctx, "guild_settings", where, values)
+		return err
+	})
+}
+
+// How many events /audit list shows.
+const (
+	defaultAuditLimit = 10
+	maxAuditLimit     = 25
+)
+
+var minAuditLimit = 1.0
+
+// Audit Subcommands
+var auditSubcommands = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string){
+	"list": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		where := map[string]interface{}{"guild_id": args["guild_id"]}
+		for option, column := range map[string]string{"region": "region", "user": "user_id", "command": "command", "outcome": "outcome"} {
+			if v := args[option]; v != "" {
+				where[column] = strings.TrimPrefix(v, "/")
+			}
+		}
+		limit := defaultAuditLimit
+		if v, err := strconv.Atoi(args["limit"]); err == nil && v > 0 && v <= maxAuditLimit {
+			limit = v
+		}
+
+		rows, err := store.Recent(context.TODO(), "audit_events", where, limit)
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		if len(rows) == 0 {
+			sendMessageEphemeral(s, i, "No audit events found.")
+			return
+		}
+
+		content := fmt.Sprintf("Last %d audit events:", len(rows))
+		for _, row := range rows {
+			line := "\n" + formatAuditEvent(newAuditEventFromRow(row))
+			if len(content)+len(line) > maxMessageLength {
+				break
+			}
+			content += line
+		}
+		sendMessageEphemeral(s, i, content)
+	},
+	"channel": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		if err := setAuditChannel(args["guild_id"], args["channel"]); err != nil {
+			sendError(s, i, err)
+			return
+		}
+		if args["channel"] == "" {
+			sendMessageEphemeral(s, i, "Stopped mirroring audit events.")
+			return
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Mirroring audit events into <#%s>.", args["channel"]))
+	},
+}
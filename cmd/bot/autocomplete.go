@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord limits for autocomplete results.
+const (
+	maxAutocompleteChoices    = 25
+	maxAutocompleteNameLength = 100
+)
+
+// instanceCache keeps recent List results per guild region so autocomplete
+// does not hit the provider on every keystroke.
+type instanceCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]instanceCacheEntry
+}
+
+type instanceCacheEntry struct {
+	instances []Instance
+	expires   time.Time
+}
+
+func newInstanceCache(ttl time.Duration) *instanceCache {
+	return &instanceCache{ttl: ttl, entries: make(map[string]instanceCacheEntry)}
+}
+
+var autocompleteCache = newInstanceCache(30 * time.Second)
+
+func instanceCacheKey(guildID string, region string) string {
+	return guildID + "/" + region
+}
+
+func (c *instanceCache) get(guildID string, region string) ([]Instance, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[instanceCacheKey(guildID, region)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.instances, true
+}
+
+func (c *instanceCache) set(guildID string, region string, instances []Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[instanceCacheKey(guildID, region)] = instanceCacheEntry{instances: instances, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *instanceCache) invalidate(guildID string, region string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, instanceCacheKey(guildID, region))
+}
+
+// Autocomplete Handlers
+var autocompleteHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+	"start":     instanceAutocomplete,
+	"stop":      instanceAutocomplete,
+	"reboot":    instanceAutocomplete,
+	"hibernate": instanceAutocomplete,
+	"alias":     instanceAutocomplete,
+	"idle":      instanceAutocomplete,
+	"schedule":  instanceAutocomplete,
+}
+
+// instanceAutocomplete suggests aliases and instances in the selected region
+// matching what the user typed.
+func instanceAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var query string
+	if focused := getFocusedOption(i.ApplicationCommandData().Options); focused != nil {
+		query = strings.ToLower(getOptionValue(focused))
+	}
+
+	region, _ := getOptionsMap(i)["region"].(string)
+	if region == "" {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{},
+		})
+		return
+	}
+
+	aliases, err := getAliases(i.GuildID, region)
+	if err != nil {
+		log.Printf("autocomplete aliases of %s in %s: %v", i.GuildID, region, err)
+	}
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, a := range aliases {
+		alias := a["alias"].(string)
+		if !strings.Contains(alias, query) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  fmt.Sprintf("%s → %s", alias, a["instance_id"]),
+			Value: alias,
+		})
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+	}
+	for _, instance := range getCachedInstances(i, region) {
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+		name := fmt.Sprintf("%s (%s) — %s", instance.Name, instance.ID, strings.ToUpper(instance.State))
+		if instance.Name == "" {
+			name = fmt.Sprintf("%s — %s", instance.ID, strings.ToUpper(instance.State))
+		}
+		if !strings.Contains(strings.ToLower(name), query) {
+			continue
+		}
+		if r := []rune(name); len(r) > maxAutocompleteNameLength {
+			name = string(r[:maxAutocompleteNameLength])
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  name,
+			Value: instance.ID,
+		})
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
+
+// getCachedInstances lists the instances in region, or nil if the lookup failed.
+func getCachedInstances(i *discordgo.InteractionCreate, region string) []Instance {
+	if instances, ok := autocompleteCache.get(i.GuildID, region); ok {
+		return instances
+	}
+
+	optionsMap, err := getOptionsMapWithCreds(i)
+	if err != nil {
+		log.Printf("autocomplete credentials of %s in %s: %v", i.GuildID, region, err)
+		return nil
+	}
+	instances, err := listInstances(convertMapValuesToString(optionsMap))
+	if err != nil {
+		log.Printf("autocomplete instances of %s in %s: %v", i.GuildID, region, err)
+		return nil
+	}
+	autocompleteCache.set(i.GuildID, region, instances)
+	return instances
+}
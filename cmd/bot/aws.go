@@ -2,95 +2,368 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 )
 
-func createEC2Client(args map[string]string) *ec2.Client {
+// Ways a guild can give ValBot access to its account.
+const (
+	// authModeKeys uses a long-lived access key pair stored in the guilds table.
+	authModeKeys = "keys"
+	// authModeRole assumes an IAM role in the guild's account using the bot's
+	// own credentials, so only the role ARN is stored.
+	authModeRole = "role"
+)
+
+// getExternalID returns the external ID ValBot sends when assuming a role of
+// the guild, generating it on first use. It is unique to the guild and never
+// taken from the guild, so another guild can't make ValBot assume the role by
+// entering its ARN (the confused deputy problem).
+func getExternalID(guildID string) (string, error) {
+	ctx := context.TODO()
+	var externalID string
+	err := store.Tx(ctx, func(tx GuildStore) error {
+		where := map[string]interface{}{"guild_id": guildID}
+		settings, err := tx.Query(ctx, "guild_settings", where)
+		if err != nil {
+			return err
+		}
+		if len(settings) > 0 && settings[0]["external_id"].(string) != "" {
+			externalID = settings[0]["external_id"].(string)
+			return nil
+		}
+
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		externalID = "valbot-" + hex.EncodeToString(b)
+		values := map[string]interface{}{"external_id": externalID}
+		if len(settings) == 0 {
+			values["guild_id"] = guildID
+			return tx.Insert(ctx, "guild_settings", values)
+		}
+		_, err = tx.Update(ctx, "guild_settings", where, values)
+		return err
+	})
+	return externalID, err
+}
+
+func createEC2Client(args map[string]string) (*ec2.Client, error) {
+	var credsProvider aws.CredentialsProvider
+	switch args["auth_mode"] {
+	case authModeRole:
+		var err error
+		credsProvider, err = getAssumeRoleCredentials(args)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		credsProvider = credentials.NewStaticCredentialsProvider(args["aws_access_key_id"], args["aws_secret_access_key"], "")
+	}
+
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(args["region"]),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(args["aws_access_key_id"], args["aws_secret_access_key"], "")))
+		config.WithCredentialsProvider(credsProvider))
 	if err != nil {
-		panic("configuration error, " + err.Error())
+		return nil, fmt.Errorf("configuration error: %w", err)
 	}
 
-	return ec2.NewFromConfig(cfg)
+	return ec2.NewFromConfig(cfg), nil
 }
 
-// EC2DescribeInstancesAPI defines the interface for the DescribeInstances function.
-// We use this interface to test the function using a mocked service.
-type EC2DescribeInstancesAPI interface {
-	DescribeInstances(ctx context.Context,
-		params *ec2.DescribeInstancesInput,
-		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
-}
+// assumeRoleCredentials keeps the temporary credentials of each assumed role
+// until they expire, so clients built for later requests can reuse them.
+var (
+	assumeRoleMu          sync.Mutex
+	assumeRoleCredentials = make(map[string]*aws.CredentialsCache)
+)
 
-// GetInstances retrieves information about your Amazon Elastic Compute Cloud (Amazon EC2) instances.
-// Inputs:
-//     c is the context of the method call, which includes the AWS Region.
-//     api is the interface that defines the method call.
-//     input defines the input arguments to the service call.
-// Output:
-//     If success, a DescribeInstancesOutput object containing the result of the service call and nil.
-//     Otherwise, nil and an error from the call to DescribeInstances.
-func GetInstances(c context.Context, api EC2DescribeInstancesAPI, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-	return api.DescribeInstances(c, input)
+// getAssumeRoleCredentials returns cached credentials for the role in args,
+// assumed with the bot's own credentials from the default AWS config chain.
+func getAssumeRoleCredentials(args map[string]string) (aws.CredentialsProvider, error) {
+	// The external ID is not part of the credentials row. It is generated per
+	// guild and kept in guild_settings, see getExternalID.
+	externalID, err := getExternalID(args["guild_id"])
+	if err != nil {
+		return nil, err
+	}
+	key := strings.Join([]string{args["guild_id"], args["region"], args["role_arn"], externalID}, "|")
+
+	assumeRoleMu.Lock()
+	defer assumeRoleMu.Unlock()
+	if creds, ok := assumeRoleCredentials[key]; ok {
+		return creds, nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(args["region"]))
+	if err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+
+	creds := aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), args["role_arn"], func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "valbot-" + args["guild_id"]
+		o.ExternalID = aws.String(externalID)
+	}))
+	assumeRoleCredentials[key] = creds
+	return creds, nil
 }
 
-func DescribeInstancesCmd(args map[string]string, instanceID string) ([]map[string]interface{}, error) {
+// verifyEC2Credentials checks that the keys in args are valid and allowed to
+// describe instances, using a dry run so nothing is actually listed.
+func verifyEC2Credentials(args map[string]string) error {
+	client, err := createEC2Client(args)
+	if err != nil {
+		return err
+	}
 
-	client := createEC2Client(args)
+	t := true
+	_, err = GetInstances(context.TODO(), client, &ec2.DescribeInstancesInput{
+		DryRun: &t,
+	})
 
-	input := &ec2.DescribeInstancesInput{}
-	if instanceID != "" {
-		input = &ec2.DescribeInstancesInput{
-			InstanceIds: []string{
-				instanceID,
-			},
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "DryRunOperation":
+			return nil
+		case "UnauthorizedOperation":
+			return errors.New("these credentials are not allowed to describe EC2 instances (ec2:DescribeInstances)")
+		case "AuthFailure":
+			return errors.New("AWS rejected these credentials, check the access key ID and secret")
 		}
 	}
+	// A failed AssumeRole is wrapped inside the EC2 operation error.
+	var opErr *smithy.OperationError
+	for e := err; errors.As(e, &opErr); e = opErr.Err {
+		if opErr.ServiceID == sts.ServiceID {
+			return fmt.Errorf("could not assume the role, check its ARN, external ID and trust policy: %w", err)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("could not verify credentials: %w", err)
+}
+
+// ec2Provider implements ServerProvider on top of the EC2 API.
+type ec2Provider struct {
+	client *ec2.Client
+}
 
-	result, err := GetInstances(context.TODO(), client, input)
+func newEC2Provider(args map[string]string) (ServerProvider, error) {
+	client, err := createEC2Client(args)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2Provider{client: client}, nil
+}
+
+func (p *ec2Provider) List(ctx context.Context) ([]Instance, error) {
+	return p.describe(ctx, &ec2.DescribeInstancesInput{})
+}
+
+func (p *ec2Provider) Describe(ctx context.Context, id string) (Instance, error) {
+	instances, err := p.describe(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{
+			id,
+		},
+	})
+	if err != nil {
+		return Instance{}, err
+	}
+	if len(instances) == 0 {
+		return Instance{}, fmt.Errorf("instance `%s` not found", id)
+	}
+	return instances[0], nil
+}
+
+func (p *ec2Provider) describe(ctx context.Context, input *ec2.DescribeInstancesInput) ([]Instance, error) {
+	result, err := GetInstances(ctx, p.client, input)
 	if err != nil {
 		fmt.Println("Got an error retrieving information about your Amazon EC2 instances:")
 		fmt.Println(err)
 		return nil, err
 	}
 
-	var returnInstances []map[string]interface{}
-
+	var instances []Instance
 	for _, r := range result.Reservations {
-
-		// fmt.Println("Reservation ID: " + *r.ReservationId)
-		// fmt.Println("Instance IDs:")
 		for _, i := range r.Instances {
-			instance := make(map[string]interface{})
-			for _, t := range i.Tags {
-				if *t.Key == "Name" {
-					instance["NAME"] = *t.Value
-				}
-			}
-
-			instance["IP"] = ""
-			if i.PublicIpAddress != nil {
-				instance["IP"] = *i.PublicIpAddress
-			}
-			instance["ID"] = *i.InstanceId
-			instance["STATUS"] = strings.ToUpper(string(i.State.Name))
-
-			returnInstances = append(returnInstances, instance)
+			instances = append(instances, newInstanceFromEC2(i))
 		}
+	}
+	return instances, nil
+}
 
-		// fmt.Println("")
+func newInstanceFromEC2(i types.Instance) Instance {
+	instance := Instance{
+		ID:           aws.ToString(i.InstanceId),
+		PublicIP:     aws.ToString(i.PublicIpAddress),
+		PrivateIP:    aws.ToString(i.PrivateIpAddress),
+		InstanceType: string(i.InstanceType),
+		LaunchTime:   aws.ToTime(i.LaunchTime),
+		Tags:         make(map[string]string, len(i.Tags)),
+	}
+	if i.State != nil {
+		instance.State = string(i.State.Name)
+	}
+	if i.HibernationOptions != nil {
+		instance.Hibernation = aws.ToBool(i.HibernationOptions.Configured)
+	}
+	if i.Placement != nil {
+		instance.AvailabilityZone = aws.ToString(i.Placement.AvailabilityZone)
+	}
+	for _, t := range i.Tags {
+		instance.Tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
 	}
-	return returnInstances, nil
+	instance.Name = instance.Tags["Name"]
+	return instance
+}
+
+func (p *ec2Provider) Start(ctx context.Context, id string) (StateChange, error) {
+	if id == "" {
+		return StateChange{}, errors.New("error instance ID must not be empty")
+	}
+
+	t := true
+	input := &ec2.StartInstancesInput{
+		InstanceIds: []string{
+			id,
+		},
+		DryRun: &t,
+	}
+
+	resp, err := StartInstance(ctx, p.client, input)
+	if err != nil {
+		fmt.Println("Got an error starting the instance")
+		fmt.Println(err)
+		return StateChange{}, err
+	}
+
+	fmt.Println("Started instance with ID " + id)
+	return newStateChangeFromEC2(id, resp.StartingInstances), nil
+}
+
+func (p *ec2Provider) Stop(ctx context.Context, id string, force bool) (StateChange, error) {
+	return p.stop(ctx, id, force, false)
+}
 
+func (p *ec2Provider) Hibernate(ctx context.Context, id string) (StateChange, error) {
+	instance, err := p.Describe(ctx, id)
+	if err != nil {
+		return StateChange{}, err
+	}
+	if !instance.Hibernation {
+		return StateChange{}, fmt.Errorf("instance `%s` is not configured for hibernation", id)
+	}
+	return p.stop(ctx, id, false, true)
+}
+
+func (p *ec2Provider) stop(ctx context.Context, id string, force bool, hibernate bool) (StateChange, error) {
+	if id == "" {
+		return StateChange{}, errors.New("error instance ID must not be empty")
+	}
+
+	t := true
+	input := &ec2.StopInstancesInput{
+		InstanceIds: []string{
+			id,
+		},
+		DryRun:    &t,
+		Force:     &force,
+		Hibernate: &hibernate,
+	}
+
+	resp, err := StopInstance(ctx, p.client, input)
+	if err != nil {
+		fmt.Println("Got an error stopping the instance")
+		fmt.Println(err)
+		return StateChange{}, err
+	}
+
+	fmt.Println("Stopped instance with ID " + id)
+	return newStateChangeFromEC2(id, resp.StoppingInstances), nil
+}
+
+func (p *ec2Provider) Reboot(ctx context.Context, id string) (StateChange, error) {
+	if id == "" {
+		return StateChange{}, errors.New("error instance ID must not be empty")
+	}
+
+	// RebootInstances does not report a state change, the instance keeps
+	// whatever state it had when the reboot was queued.
+	instance, err := p.Describe(ctx, id)
+	if err != nil {
+		return StateChange{}, err
+	}
+
+	t := true
+	input := &ec2.RebootInstancesInput{
+		InstanceIds: []string{
+			id,
+		},
+		DryRun: &t,
+	}
+
+	_, err = RebootInstance(ctx, p.client, input)
+	if err != nil {
+		fmt.Println("Got an error rebooting the instance")
+		fmt.Println(err)
+		return StateChange{}, err
+	}
+
+	fmt.Println("Rebooted instance with ID " + id)
+	return StateChange{ID: id, Previous: instance.State, Current: instance.State}, nil
+}
+
+func newStateChangeFromEC2(id string, changes []types.InstanceStateChange) StateChange {
+	change := StateChange{ID: id}
+	for _, c := range changes {
+		if aws.ToString(c.InstanceId) != id {
+			continue
+		}
+		if c.PreviousState != nil {
+			change.Previous = string(c.PreviousState.Name)
+		}
+		if c.CurrentState != nil {
+			change.Current = string(c.CurrentState.Name)
+		}
+	}
+	return change
+}
+
+// EC2DescribeInstancesAPI defines the interface for the DescribeInstances function.
+// We use this interface to test the function using a mocked service.
+type EC2DescribeInstancesAPI interface {
+	DescribeInstances(ctx context.Context,
+		params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+}
+
+// GetInstances retrieves information about your Amazon Elastic Compute Cloud (Amazon EC2) instances.
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region.
+//     api is the interface that defines the method call.
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a DescribeInstancesOutput object containing the result of the service call and nil.
+//     Otherwise, nil and an error from the call to DescribeInstances.
+func GetInstances(c context.Context, api EC2DescribeInstancesAPI, input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return api.DescribeInstances(c, input)
 }
 
 // EC2StartInstancesAPI defines the interface for the StartInstances function.
@@ -123,34 +396,6 @@ func StartInstance(c context.Context, api EC2StartInstancesAPI, input *ec2.Start
 	return resp, err
 }
 
-func StartInstancesCmd(args map[string]string, instanceID string) error {
-
-	if instanceID == "" {
-		fmt.Println("You must supply an instance ID (-i INSTANCE-ID")
-		return errors.New("error instance ID must not be empty")
-	}
-
-	client := createEC2Client(args)
-
-	t := true
-	input := &ec2.StartInstancesInput{
-		InstanceIds: []string{
-			instanceID,
-		},
-		DryRun: &t,
-	}
-
-	_, err := StartInstance(context.TODO(), client, input)
-	if err != nil {
-		fmt.Println("Got an error starting the instance")
-		fmt.Println(err)
-		return err
-	}
-
-	fmt.Println("Started instance with ID " + instanceID)
-	return nil
-}
-
 // EC2StopInstancesAPI defines the interface for the StopInstances function.
 // We use this interface to test the function using a mocked service.
 type EC2StopInstancesAPI interface {
@@ -181,28 +426,32 @@ func StopInstance(c context.Context, api EC2StopInstancesAPI, input *ec2.StopIns
 	return resp, err
 }
 
-func StopInstancesCmd(args map[string]string, instanceID string) error {
-	if instanceID == "" {
-		fmt.Println("You must supply an instance ID (-i INSTANCE-ID")
-		return errors.New("error instance ID must not be empty")
-	}
+// EC2RebootInstancesAPI defines the interface for the RebootInstances function.
+// We use this interface to test the function using a mocked service.
+type EC2RebootInstancesAPI interface {
+	RebootInstances(ctx context.Context,
+		params *ec2.RebootInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.RebootInstancesOutput, error)
+}
 
-	client := createEC2Client(args)
-	t := true
-	input := &ec2.StopInstancesInput{
-		InstanceIds: []string{
-			instanceID,
-		},
-		DryRun: &t,
-	}
+// RebootInstance reboots an Amazon Elastic Compute Cloud (Amazon EC2) instance.
+// Inputs:
+//     c is the context of the method call, which includes the AWS Region.
+//     api is the interface that defines the method call.
+//     input defines the input arguments to the service call.
+// Output:
+//     If success, a RebootInstancesOutput object containing the result of the service call and nil.
+//     Otherwise, nil and an error from the call to RebootInstances.
+func RebootInstance(c context.Context, api EC2RebootInstancesAPI, input *ec2.RebootInstancesInput) (*ec2.RebootInstancesOutput, error) {
+	resp, err := api.RebootInstances(c, input)
 
-	_, err := StopInstance(context.TODO(), client, input)
-	if err != nil {
-		fmt.Println("Got an error stopping the instance")
-		fmt.Println(err)
-		return err
+	var apiErr smithy.APIError
+	f := false
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation" {
+		fmt.Println("User has permission to reboot instances.")
+		input.DryRun = &f
+		return api.RebootInstances(c, input)
 	}
 
-	fmt.Println("Stopped instance with ID " + instanceID)
-	return nil
+	return resp, err
 }
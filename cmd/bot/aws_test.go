@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetExternalID(t *testing.T) {
+	useTestStore(t)
+	if err := setAuditChannel(testGuildID, "audit"); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := getExternalID(testGuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(id, "valbot-") || len(id) != len("valbot-")+32 {
+		t.Errorf("getExternalID() = %q, want a generated ID", id)
+	}
+	if again, err := getExternalID(testGuildID); err != nil || again != id {
+		t.Errorf("getExternalID() again = %q, %v, want %q", again, err, id)
+	}
+	if other, err := getExternalID("other"); err != nil || other == id {
+		t.Errorf("getExternalID(other) = %q, %v, want a different ID", other, err)
+	}
+	if channelID, err := getAuditChannel(testGuildID); err != nil || channelID != "audit" {
+		t.Errorf("audit channel = %q, %v, want it kept", channelID, err)
+	}
+}
+
+func TestRoleCredentialsOmitExternalID(t *testing.T) {
+	useTestStore(t)
+	args := map[string]interface{}{
+		"guild_id":   testGuildID,
+		"region":     testRegion,
+		"auth_mode":  authModeRole,
+		"role_arn":   "arn:aws:iam::123456789012:role/valbot",
+		"updated_by": testAdminID,
+	}
+	if err := saveCredsToDB(args); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := queryDB("guilds", map[string]interface{}{"guild_id": testGuildID})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("guilds = %v, %v", rows, err)
+	}
+	for _, column := range []string{"external_id", "previous_external_id"} {
+		if _, ok := rows[0][column]; ok {
+			t.Errorf("guilds still has a %s column", column)
+		}
+	}
+
+	// An external ID sent along, e.g. by an old form, is refused.
+	args["region"] = "us-west-2"
+	args["external_id"] = "chosen-by-guild"
+	if err := saveCredsToDB(args); err == nil {
+		t.Error("saveCredsToDB() with an external ID succeeded")
+	}
+}
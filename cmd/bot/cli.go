@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// CLI Commands, run instead of the bot when passed as arguments, e.g. `bot reencrypt`.
+var cliCommands = map[string]func(args []string) error{
+	"migrate":   migrateCmd,
+	"reencrypt": reencryptCmd,
+}
+
+func runCLI(args []string) error {
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		names := make([]string, 0, len(cliCommands))
+		for name := range cliCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown command %q, expected one of: %s", args[0], strings.Join(names, ", "))
+	}
+	return cmd(args[1:])
+}
+
+// reencryptCmd moves every stored secret to a fresh data key wrapped by the
+// current key wrapper, bound to its guild row, inside a single transaction.
+// Run it after rotating VBOT_AES_KEY or changing --kms so the old master key
+// can be retired, and once to bind secrets saved before binding existed.
+func reencryptCmd(args []string) error {
+	ctx := context.TODO()
+	columns := make([]string, 0, len(sensitiveKeys))
+	for k := range sensitiveKeys {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	var count int
+	err := store.Tx(ctx, func(tx GuildStore) error {
+		guilds, err := tx.Query(ctx, "guilds", nil)
+		if err != nil {
+			return err
+		}
+
+		for _, g := range guilds {
+			values := make(map[string]interface{})
+			// Credentials replaced by /init are kept under previous_ until
+			// they expire, and need to move to the new master key too.
+			for _, prefix := range []string{"", "previous_"} {
+				if prefix != "" && g[prefix+"auth_mode"] == "" {
+					continue
+				}
+				var oldKey []byte
+				if wrappedKey := g[prefix+"data_key"].(string); wrappedKey != "" {
+					oldKey, err = unwrapDataKey(ctx, wrappedKey)
+					if err != nil {
+						return fmt.Errorf("guild row %s: %w", g["id"], err)
+					}
+				}
+				newKey, wrappedKey, err := newDataKey(ctx)
+				if err != nil {
+					return err
+				}
+
+				values[prefix+"data_key"] = wrappedKey
+				for _, column := range columns {
+					v := g[prefix+column].(string)
+					if v == "" {
+						continue
+					}
+					ad := credentialAD(g["guild_id"].(string), g["region"].(string), column)
+					plaintext, err := decrypt(oldKey, v, ad)
+					if err != nil {
+						return fmt.Errorf("guild row %s: %w", g["id"], err)
+					}
+					values[prefix+column], err = encrypt(newKey, plaintext, ad)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			if _, err := tx.Update(ctx, "guilds", map[string]interface{}{"id": g["id"]}, values); err != nil {
+				return err
+			}
+		}
+		count = len(guilds)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Re-encrypted %d guild rows with new data keys wrapped by %s", count, keyWrapper.Name())
+	return nil
+}
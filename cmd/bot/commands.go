@@ -2,11 +2,16 @@ package main
 
 import (
 	// "fmt"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/ecoshub/stable"
 )
 
 var regionList = [...]string{"us-east-1", "us-east-2", "us-west-1", "us-west-2", "ca-central-1"}
@@ -45,6 +50,59 @@ func getRegionComponentOptions() []discordgo.SelectMenuOption {
 	return menuOptions
 }
 
+// Server helpers
+var serverOption = &discordgo.ApplicationCommandOption{
+	Name:         "server",
+	Description:  "Instance ID, Name or alias",
+	Type:         discordgo.ApplicationCommandOptionString,
+	Required:     true,
+	Autocomplete: true,
+}
+
+var aliasOption = &discordgo.ApplicationCommandOption{
+	Name:        "alias",
+	Description: "Alias",
+	Type:        discordgo.ApplicationCommandOptionString,
+	Required:    true,
+}
+
+var managedServerOption = &discordgo.ApplicationCommandOption{
+	Name:        "server",
+	Description: "Instance ID or Name",
+	Type:        discordgo.ApplicationCommandOptionString,
+	Required:    false,
+}
+
+var managedTagOption = &discordgo.ApplicationCommandOption{
+	Name:        "tag",
+	Description: "Instance tag, e.g. valbot:managed=true",
+	Type:        discordgo.ApplicationCommandOptionString,
+	Required:    false,
+}
+
+// Permission helpers
+var capabilityOption = &discordgo.ApplicationCommandOption{
+	Name:        "capability",
+	Description: "view: status, power: start and stop, admin: everything",
+	Type:        discordgo.ApplicationCommandOptionString,
+	Required:    true,
+	Choices:     getCapabilityChoices(),
+}
+
+var permissionRoleOption = &discordgo.ApplicationCommandOption{
+	Name:        "role",
+	Description: "Role",
+	Type:        discordgo.ApplicationCommandOptionRole,
+	Required:    false,
+}
+
+var permissionUserOption = &discordgo.ApplicationCommandOption{
+	Name:        "user",
+	Description: "User",
+	Type:        discordgo.ApplicationCommandOptionUser,
+	Required:    false,
+}
+
 // Commands, Options, Choices
 var commands = []*discordgo.ApplicationCommand{
 
@@ -58,16 +116,20 @@ var commands = []*discordgo.ApplicationCommand{
 		Options: []*discordgo.ApplicationCommandOption{
 			regionOption,
 			{
-				Name:        "aws_access_key_id",
-				Description: "AWS Access Key ID",
+				Name:        "mode",
+				Description: "Use an access key pair or let ValBot assume an IAM role",
 				Type:        discordgo.ApplicationCommandOptionString,
-				Required:    true,
-			},
-			{
-				Name:        "aws_secret_access_key",
-				Description: "AWS Secret Access Key",
-				Type:        discordgo.ApplicationCommandOptionString,
-				Required:    true,
+				Required:    false,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{
+						Name:  "Access keys",
+						Value: authModeKeys,
+					},
+					{
+						Name:  "Assume role",
+						Value: authModeRole,
+					},
+				},
 			},
 		},
 	},
@@ -78,11 +140,25 @@ var commands = []*discordgo.ApplicationCommand{
 			regionOption,
 		},
 	},
+	{
+		Name:        "init-rollback",
+		Description: "Restore the credentials replaced by the last /init",
+		Options: []*discordgo.ApplicationCommandOption{
+			regionOption,
+		},
+	},
 	{
 		Name:        "status",
 		Description: "Servers Status",
 		Options: []*discordgo.ApplicationCommandOption{
 			regionOption,
+			{
+				Name:        "format",
+				Description: "Output format",
+				Type:        discordgo.ApplicationCommandOptionString,
+				Required:    false,
+				Choices:     getStatusFormatChoices(),
+			},
 			// {
 			// 	Name:        "instance_id",
 			// 	Description: "Instance ID",
@@ -96,12 +172,7 @@ var commands = []*discordgo.ApplicationCommand{
 		Description: "Start Servers",
 		Options: []*discordgo.ApplicationCommandOption{
 			regionOption,
-			{
-				Name:        "instance_id",
-				Description: "Instance ID",
-				Type:        discordgo.ApplicationCommandOptionString,
-				Required:    true,
-			},
+			serverOption,
 		},
 	},
 	{
@@ -109,11 +180,318 @@ var commands = []*discordgo.ApplicationCommand{
 		Description: "Stop Servers",
 		Options: []*discordgo.ApplicationCommandOption{
 			regionOption,
+			serverOption,
 			{
-				Name:        "instance_id",
-				Description: "Instance ID",
-				Type:        discordgo.ApplicationCommandOptionString,
-				Required:    true,
+				Name:        "force",
+				Description: "Force stop without a graceful shutdown",
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Required:    false,
+			},
+		},
+	},
+	{
+		Name:        "reboot",
+		Description: "Reboot Servers",
+		Options: []*discordgo.ApplicationCommandOption{
+			regionOption,
+			serverOption,
+		},
+	},
+	{
+		Name:        "hibernate",
+		Description: "Hibernate Servers",
+		Options: []*discordgo.ApplicationCommandOption{
+			regionOption,
+			serverOption,
+		},
+	},
+	{
+		Name:        "alias",
+		Description: "Manage Server Aliases",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "add",
+				Description: "Add a server alias",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+					serverOption,
+					aliasOption,
+				},
+			},
+			{
+				Name:        "remove",
+				Description: "Remove a server alias",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+					aliasOption,
+				},
+			},
+			{
+				Name:        "list",
+				Description: "List server aliases",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+				},
+			},
+		},
+	},
+	{
+		Name:        "servers",
+		Description: "Manage Servers ValBot Can Control",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "add",
+				Description: "Allow ValBot to see and control a server",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+					managedServerOption,
+					managedTagOption,
+				},
+			},
+			{
+				Name:        "remove",
+				Description: "Stop ValBot from seeing and controlling a server",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+					managedServerOption,
+					managedTagOption,
+				},
+			},
+			{
+				Name:        "list",
+				Description: "List servers ValBot can control",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+				},
+			},
+		},
+	},
+	{
+		Name:        "permissions",
+		Description: "Manage Who Can Use ValBot",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "grant",
+				Description: "Grant a permission to a role or user",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					capabilityOption,
+					permissionRoleOption,
+					permissionUserOption,
+				},
+			},
+			{
+				Name:        "revoke",
+				Description: "Revoke a permission from a role or user",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					capabilityOption,
+					permissionRoleOption,
+					permissionUserOption,
+				},
+			},
+			{
+				Name:        "list",
+				Description: "List granted permissions",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+			},
+		},
+	},
+	{
+		Name:        "idle",
+		Description: "Stop Servers Nobody Is Playing On",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "set",
+				Description: "Set how to query a server and when to stop it",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+					serverOption,
+					{
+						Name:        "query",
+						Description: "Protocol used to ask the game server for its players",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+						Choices:     getGameQueryChoices(),
+					},
+					{
+						Name:        "port",
+						Description: "Port the game server answers queries on",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Required:    true,
+						MinValue:    &minPort,
+						MaxValue:    maxPort,
+					},
+					{
+						Name:        "game-port",
+						Description: "Port players connect to, if not the query port",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Required:    false,
+						MinValue:    &minPort,
+						MaxValue:    maxPort,
+					},
+					{
+						Name:        "minutes",
+						Description: "Stop the server after this many minutes without players, 0 never stops it",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Required:    false,
+						MinValue:    &minIdleMinutes,
+					},
+					{
+						Name:         "channel",
+						Description:  "Channel to warn in before stopping, defaults to this one",
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Required:     false,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Name:        "remove",
+				Description: "Remove the settings of a server",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+					serverOption,
+				},
+			},
+			{
+				Name:        "list",
+				Description: "List server settings",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+				},
+			},
+		},
+	},
+	{
+		Name:        "schedule",
+		Description: "Start and Stop Servers on a Schedule",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "add",
+				Description: "Start or stop a server on a cron schedule",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+					serverOption,
+					{
+						Name:        "action",
+						Description: "What to do with the server",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+						Choices:     getScheduleActionChoices(),
+					},
+					{
+						Name:        "cron",
+						Description: "When to run, as minute hour day month weekday, e.g. 0 19 * * FRI",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+					{
+						Name:        "timezone",
+						Description: "IANA timezone the schedule runs in, e.g. Europe/Berlin, defaults to UTC",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    false,
+					},
+					{
+						Name:         "channel",
+						Description:  "Channel to post the outcome of each run in, defaults to this one",
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Required:     false,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Name:        "remove",
+				Description: "Remove a schedule",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "id",
+						Description: "ID of the schedule, as shown by /schedule list",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Required:    true,
+					},
+				},
+			},
+			{
+				Name:        "list",
+				Description: "List schedules",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					regionOption,
+				},
+			},
+		},
+	},
+	{
+		Name:        "audit",
+		Description: "Show Who Did What with ValBot",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "list",
+				Description: "List recent audit events",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "region",
+						Description: "AWS Region",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    false,
+						Choices:     getRegionChoices(),
+					},
+					{
+						Name:        "user",
+						Description: "Only events of this user",
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Required:    false,
+					},
+					{
+						Name:        "command",
+						Description: "Only events of this command, e.g. start or alias add",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    false,
+					},
+					{
+						Name:        "outcome",
+						Description: "Only events with this outcome",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    false,
+						Choices:     getOutcomeChoices(),
+					},
+					{
+						Name:        "limit",
+						Description: "How many events to show",
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Required:    false,
+						MinValue:    &minAuditLimit,
+						MaxValue:    maxAuditLimit,
+					},
+				},
+			},
+			{
+				Name:        "channel",
+				Description: "Mirror audit events into a channel, or stop when none is given",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:         "channel",
+						Description:  "Channel to mirror audit events into",
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Required:     false,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
 			},
 		},
 	},
@@ -122,124 +500,485 @@ var commands = []*discordgo.ApplicationCommand{
 // Command Handlers
 var commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
 	"help": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		sendMessage(s, i, "Setup Valbot with `/init` to use the other commands. AWS Region must be specified for all commands.")
+		sendMessage(s, i, "Setup Valbot with `/init` to use the other commands. AWS Region must be specified for all commands. Servers must be registered with `/servers add` before ValBot can see them, and can be given by instance ID, `Name` tag or an alias added with `/alias add`.")
 	},
 	"init": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		optionsMap := getOptionsMap(i)
-
-		err := saveCredsToDB(optionsMap)
+		region := optionsMap["region"].(string)
+		mode, _ := optionsMap["mode"].(string)
+		current, err := queryDB("guilds", map[string]interface{}{
+			"guild_id": i.GuildID,
+			"region":   region,
+		})
 		if err != nil {
-			log.Println(err)
-			sendMessageEphemeral(s, i, fmt.Sprintf("Something went wrong...\n```%s```", err))
-		} else {
-			sendMessageEphemeral(s, i, fmt.Sprintf("Initialized ValBot for Guild ID: `%s` Region: `%s`", optionsMap["guild_id"], optionsMap["region"]))
+			sendError(s, i, err)
+			return
+		}
+		if len(current) > 0 {
+			sendReplaceConfirm(s, i, region, mode, current[0])
+			return
 		}
+		sendInitModal(s, i, region, mode)
 	},
 	"init-delete": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		optionsMap := getOptionsMap(i)
-		// data := getCredsFromDB(optionsMap)
-		deleteDB(optionsMap)
+		if _, err := deleteDB("guilds", map[string]interface{}{
+			"guild_id": optionsMap["guild_id"],
+			"region":   optionsMap["region"],
+		}); err != nil {
+			sendError(s, i, err)
+			return
+		}
 		sendMessageEphemeral(s, i, fmt.Sprintf("Deleted ValBot AWS Credentials for Guild ID: `%s` Region: `%s`", optionsMap["guild_id"], optionsMap["region"]))
 	},
+	"init-rollback": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMap := getOptionsMap(i)
+		restoredBy, err := rollbackCredsInDB(map[string]interface{}{
+			"guild_id":   i.GuildID,
+			"region":     optionsMap["region"],
+			"updated_by": i.Member.User.ID,
+		})
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		autocompleteCache.invalidate(i.GuildID, optionsMap["region"].(string))
+		content := fmt.Sprintf("Restored the previous ValBot AWS Credentials for Guild ID: `%s` Region: `%s`", optionsMap["guild_id"], optionsMap["region"])
+		if restoredBy != "" {
+			content += fmt.Sprintf(", saved by <@%s>", restoredBy)
+		}
+		sendMessageEphemeral(s, i, content)
+	},
 	"status": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		optionsMap := getOptionsMapWithCreds(i)
+		optionsMap, err := getOptionsMapWithCreds(i)
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
 		optionsMapStr := convertMapValuesToString(optionsMap)
 		deferMessageStatus(s, i)
-		instances, err := DescribeInstancesCmd(optionsMapStr, optionsMapStr["instance_id"])
+		instances, err := listInstances(optionsMapStr)
 		if err != nil {
-			deferMessageUpdate(s, i, fmt.Sprintf("Something went wrong...\n```%s```", err))
+			updateError(s, i, err)
 		} else {
 			sendInstanceStatus(s, i, instances, optionsMap)
 		}
 	},
-	"start": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		optionsMap := getOptionsMapWithCreds(i)
+	"alias": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMap, err := getOptionsMapWithCreds(i)
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		optionsMapStr := convertMapValuesToString(optionsMap)
+		if h, ok := aliasSubcommands[optionsMapStr["subcommand"]]; ok {
+			h(s, i, optionsMapStr)
+		}
+	},
+	"servers": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMap, err := getOptionsMapWithCreds(i)
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		optionsMapStr := convertMapValuesToString(optionsMap)
+		if h, ok := serversSubcommands[optionsMapStr["subcommand"]]; ok {
+			h(s, i, optionsMapStr)
+		}
+	},
+	"permissions": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMapStr := convertMapValuesToString(getOptionsMap(i))
+		if h, ok := permissionsSubcommands[optionsMapStr["subcommand"]]; ok {
+			h(s, i, optionsMapStr)
+		}
+	},
+	"idle": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMap, err := getOptionsMapWithCreds(i)
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		optionsMapStr := convertMapValuesToString(optionsMap)
+		if h, ok := idleSubcommands[optionsMapStr["subcommand"]]; ok {
+			h(s, i, optionsMapStr)
+		}
+	},
+	"schedule": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMapStr := convertMapValuesToString(getOptionsMap(i))
+		if optionsMapStr["subcommand"] == "add" {
+			optionsMap, err := getOptionsMapWithCreds(i)
+			if err != nil {
+				sendError(s, i, err)
+				return
+			}
+			optionsMapStr = convertMapValuesToString(optionsMap)
+		}
+		if h, ok := scheduleSubcommands[optionsMapStr["subcommand"]]; ok {
+			h(s, i, optionsMapStr)
+		}
+	},
+	"audit": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMapStr := convertMapValuesToString(getOptionsMap(i))
+		if h, ok := auditSubcommands[optionsMapStr["subcommand"]]; ok {
+			h(s, i, optionsMapStr)
+		}
+	},
+	"start":  powerHandler("Starting", "running", startInstance),
+	"stop":   powerHandler("Stopping", "stopped", stopInstance),
+	"reboot": powerHandler("Rebooting", "", rebootInstance),
+	"hibernate": powerHandler("Hibernating", "stopped", func(p ServerProvider, args map[string]string) (StateChange, error) {
+		return p.Hibernate(context.TODO(), args["instance_id"])
+	}),
+}
+
+// Power actions shared by the slash commands and the status buttons.
+func startInstance(p ServerProvider, args map[string]string) (StateChange, error) {
+	return p.Start(context.TODO(), args["instance_id"])
+}
+
+func stopInstance(p ServerProvider, args map[string]string) (StateChange, error) {
+	return p.Stop(context.TODO(), args["instance_id"], args["force"] == "true")
+}
+
+func rebootInstance(p ServerProvider, args map[string]string) (StateChange, error) {
+	return p.Reboot(context.TODO(), args["instance_id"])
+}
+
+// powerHandler builds the handler for a command that changes the power state of an instance.
+// When target is set, the response is updated again once the instance reaches that state.
+func powerHandler(verb string, target string, action func(p ServerProvider, args map[string]string) (StateChange, error)) func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMap, err := getOptionsMapWithCreds(i)
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
 		optionsMapStr := convertMapValuesToString(optionsMap)
 		deferMessage(s, i)
-		err := StartInstancesCmd(optionsMapStr, optionsMapStr["instance_id"])
+		started := time.Now()
+		provider, err := getProvider(optionsMapStr)
+		if err == nil {
+			optionsMapStr["instance_id"], err = resolveInstance(provider, optionsMapStr)
+		}
 		if err != nil {
-			deferMessageUpdate(s, i, fmt.Sprintf("Something went wrong...\n```%s```", err))
-		} else {
+			updateError(s, i, err)
+			return
+		}
+		runPowerAction(s, i, provider, optionsMapStr, started, verb, target, action)
+	}
+}
 
-			deferMessageUpdate(s, i, fmt.Sprintf("Starting instance `%s` in `%s`. Check `/status region: %s` to see more info.", optionsMapStr["instance_id"], optionsMapStr["region"], optionsMapStr["region"]))
+// powerButtonHandler runs action on the instance of the status button pressed,
+// whose custom ID is name:<region>:<instance ID>.
+func powerButtonHandler(verb string, target string, action func(p ServerProvider, args map[string]string) (StateChange, error)) func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		args := getCustomIDArgs(i.MessageComponentData().CustomID)
+		if len(args) < 2 {
+			return
+		}
+		optionsMap, err := getOptionsMapWithCredsForRegion(i.GuildID, args[0])
+		if err != nil {
+			sendError(s, i, err)
+			return
 		}
-	},
-	"stop": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		optionsMap := getOptionsMapWithCreds(i)
 		optionsMapStr := convertMapValuesToString(optionsMap)
+		optionsMapStr["instance_id"] = args[1]
 		deferMessage(s, i)
-		err := StopInstancesCmd(optionsMapStr, optionsMapStr["instance_id"])
+		started := time.Now()
+		provider, err := getProvider(optionsMapStr)
 		if err != nil {
-			deferMessageUpdate(s, i, fmt.Sprintf("Something went wrong...\n```%s```", err))
-		} else {
-			deferMessageUpdate(s, i, fmt.Sprintf("Stopping instance `%s` in `%s`. Check `/status region: %s` to see more info.", optionsMapStr["instance_id"], optionsMapStr["region"], optionsMapStr["region"]))
+			updateError(s, i, err)
+			return
 		}
-	},
+		runPowerAction(s, i, provider, optionsMapStr, started, verb, target, action)
+	}
+}
+
+// runPowerAction runs action on the instance in args and, unless target is
+// empty, waits for it to reach target, reporting progress in the deferred message.
+func runPowerAction(s *discordgo.Session, i *discordgo.InteractionCreate, provider ServerProvider, args map[string]string, started time.Time, verb string, target string, action func(p ServerProvider, args map[string]string) (StateChange, error)) {
+	instanceID := args["instance_id"]
+	region := args["region"]
+	auditInstance(i, instanceID)
+	change, err := action(provider, args)
+	if err != nil {
+		updateError(s, i, err)
+		return
+	}
+
+	autocompleteCache.invalidate(i.GuildID, region)
+	if target == "" || change.Current == target {
+		deferMessageUpdate(s, i, fmt.Sprintf("%s instance `%s` in `%s`: %s. Check `/status region: %s` to see more info.", verb, instanceID, region, change, region))
+		return
+	}
+
+	deferMessageUpdate(s, i, fmt.Sprintf("%s instance `%s` in `%s`: %s. Waiting for it to be `%s`...", verb, instanceID, region, change, strings.ToUpper(target)))
+	instance, err := waitForState(context.TODO(), provider, instanceID, target, *WaitTimeout)
+	elapsed := time.Since(started).Truncate(time.Second)
+	switch {
+	case errors.Is(err, errWaitTimeout):
+		auditFailure(i, err)
+		deferMessageUpdate(s, i, fmt.Sprintf("Instance `%s` in `%s` did not become `%s` within %s, last state was `%s`. Check `/status region: %s` to see more info.", instanceID, region, strings.ToUpper(target), elapsed, strings.ToUpper(instance.State), region))
+	case err != nil:
+		auditFailure(i, err)
+		deferMessageUpdate(s, i, fmt.Sprintf("Something went wrong while waiting for instance `%s`...\n```%s```", instanceID, err))
+	case instance.PublicIP != "":
+		deferMessageUpdate(s, i, fmt.Sprintf("Instance `%s` in `%s` is `%s` after %s. IP: `%s`", instanceID, region, strings.ToUpper(instance.State), elapsed, instance.PublicIP))
+	default:
+		deferMessageUpdate(s, i, fmt.Sprintf("Instance `%s` in `%s` is `%s` after %s.", instanceID, region, strings.ToUpper(instance.State), elapsed))
+	}
 }
 
 // Component Handlers
 var componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+	"keep_alive":  keepAlive,
+	"srv_start":   powerButtonHandler("Starting", "running", startInstance),
+	"srv_stop":    powerButtonHandler("Stopping", "stopped", stopInstance),
+	"srv_reboot":  powerButtonHandler("Rebooting", "", rebootInstance),
+	"srv_connect": sendConnectInfo,
 	"refresh_status": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		optionsMap := getOptionsMapWithCredsFromComponent(i)
+		optionsMap, err := getOptionsMapWithCredsFromComponent(i)
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
 		optionsMapStr := convertMapValuesToString(optionsMap)
 		deferMessageStatus(s, i)
-		instances, err := DescribeInstancesCmd(optionsMapStr, optionsMapStr["instance_id"])
+		instances, err := listInstances(optionsMapStr)
 
 		if err != nil {
-			deferMessageUpdate(s, i, fmt.Sprintf("Something went wrong...\n```%s```", err))
+			updateError(s, i, err)
 		} else {
 			s.ChannelMessageDelete(i.Message.ChannelID, i.Message.ID)
 			sendInstanceStatus(s, i, instances, optionsMap)
 		}
 
 	},
+	"status_page": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		args := getCustomIDArgs(i.MessageComponentData().CustomID)
+		if len(args) < 2 {
+			return
+		}
+		updateStatusPage(s, i, args[0], args[1], "")
+	},
+	"srv_select": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		args := getCustomIDArgs(i.MessageComponentData().CustomID)
+		values := i.MessageComponentData().Values
+		if len(args) < 2 || len(values) == 0 {
+			return
+		}
+		updateStatusPage(s, i, args[0], args[1], values[0])
+	},
+	"init_replace": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		args := getCustomIDArgs(i.MessageComponentData().CustomID)
+		if len(args) < 2 {
+			return
+		}
+		sendInitModal(s, i, args[0], args[1])
+	},
+	"init_role_form": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		args := getCustomIDArgs(i.MessageComponentData().CustomID)
+		if len(args) < 1 {
+			return
+		}
+		sendRoleModal(s, i, args[0])
+	},
+	"init_cancel": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    "Kept the existing credentials.",
+				Components: []discordgo.MessageComponent{},
+			},
+		})
+	},
+}
+
+// updateStatusPage shows page of the /status embed of region in place, with
+// the action buttons working on the selected instance.
+func updateStatusPage(s *discordgo.Session, i *discordgo.InteractionCreate, region string, page string, selected string) {
+	optionsMap, err := getOptionsMapWithCredsForRegion(i.GuildID, region)
+	if err != nil {
+		sendError(s, i, err)
+		return
+	}
+	// Only the embed format has pages.
+	optionsMap["format"] = "embed"
+	optionsMap["page"] = page
+	optionsMap["selected"] = selected
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	instances, err := listInstances(convertMapValuesToString(optionsMap))
+	if err != nil {
+		updateError(s, i, err)
+	} else {
+		sendInstanceStatus(s, i, instances, optionsMap)
+	}
+}
+
+// Modal Handlers
+var modalHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+	"init_credentials": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMap := getOptionsMapFromModal(i)
+		optionsMap["auth_mode"] = authModeKeys
+		initCredentials(s, i, optionsMap)
+	},
+	"init_role": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		optionsMap := getOptionsMapFromModal(i)
+		optionsMap["auth_mode"] = authModeRole
+		initCredentials(s, i, optionsMap)
+	},
+}
+
+// initCredentials verifies the credentials submitted through a modal and saves them.
+func initCredentials(s *discordgo.Session, i *discordgo.InteractionCreate, optionsMap map[string]interface{}) {
+	deferMessageEphemeral(s, i)
+	optionsMap["updated_by"] = i.Member.User.ID
+
+	err := verifyEC2Credentials(convertMapValuesToString(optionsMap))
+	if err == nil {
+		err = saveCredsToDB(optionsMap)
+	}
+	if err != nil {
+		log.Println(err)
+		updateError(s, i, err)
+	} else {
+		deferMessageUpdate(s, i, fmt.Sprintf("Initialized ValBot for Guild ID: `%s` Region: `%s`", optionsMap["guild_id"], optionsMap["region"]))
+	}
 }
 
 // Helper functions
+
+// getCustomIDName returns the handler name of a component or modal custom ID.
+// Custom IDs may carry arguments after the name, separated by colons.
+func getCustomIDName(customID string) string {
+	name, _, _ := strings.Cut(customID, ":")
+	return name
+}
+
+// getCustomIDArgs returns the arguments after the handler name of a custom ID.
+func getCustomIDArgs(customID string) []string {
+	args := strings.Split(customID, ":")
+	return args[1:]
+}
+
 func getOptionsMap(i *discordgo.InteractionCreate) map[string]interface{} {
 	options := i.ApplicationCommandData().Options
 	optionsMap := make(map[string]interface{})
 	optionsMap["guild_id"] = i.GuildID
 
 	for _, opt := range options {
-		optionsMap[opt.Name] = opt.StringValue()
+		if opt.Type == discordgo.ApplicationCommandOptionSubCommand {
+			optionsMap["subcommand"] = opt.Name
+			for _, subOpt := range opt.Options {
+				optionsMap[subOpt.Name] = getOptionValue(subOpt)
+			}
+			continue
+		}
+		optionsMap[opt.Name] = getOptionValue(opt)
 	}
 	return optionsMap
 }
 
-func getOptionsMapWithCreds(i *discordgo.InteractionCreate) map[string]interface{} {
-	options := i.ApplicationCommandData().Options
-	optionsMap := make(map[string]interface{})
-	optionsMap["guild_id"] = i.GuildID
+func getOptionsMapWithCreds(i *discordgo.InteractionCreate) (map[string]interface{}, error) {
+	optionsMap := getOptionsMap(i)
 
-	for _, opt := range options {
-		optionsMap[opt.Name] = opt.StringValue()
+	data, err := getCredsFromDB(optionsMap)
+	if err != nil {
+		return nil, err
 	}
-
-	data := getCredsFromDB(optionsMap)
 	for _, d := range data {
 		for k, v := range d {
 			optionsMap[k] = v
 		}
 	}
-	return optionsMap
+	return optionsMap, nil
 }
 
-func getOptionsMapWithCredsFromComponent(i *discordgo.InteractionCreate) map[string]interface{} {
+// getFocusedOption returns the option the user is typing in during autocomplete.
+func getFocusedOption(options []*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, opt := range options {
+		if opt.Focused {
+			return opt
+		}
+		if focused := getFocusedOption(opt.Options); focused != nil {
+			return focused
+		}
+	}
+	return nil
+}
+
+func getOptionsMapWithCredsFromComponent(i *discordgo.InteractionCreate) (map[string]interface{}, error) {
 	options := i.MessageComponentData().Values
+	return getOptionsMapWithCredsForRegion(i.GuildID, options[0])
+}
+
+// getOptionsMapWithCredsForRegion returns the credentials of the guild region
+// for components that carry the region in their custom ID.
+func getOptionsMapWithCredsForRegion(guildID string, region string) (map[string]interface{}, error) {
 	optionsMap := make(map[string]interface{})
-	optionsMap["guild_id"] = i.GuildID
-	optionsMap["region"] = options[0]
+	optionsMap["guild_id"] = guildID
+	optionsMap["region"] = region
 
-	data := getCredsFromDB(optionsMap)
+	data, err := getCredsFromDB(optionsMap)
+	if err != nil {
+		return nil, err
+	}
 	for _, d := range data {
 		for k, v := range d {
 			optionsMap[k] = v
 		}
 	}
+	return optionsMap, nil
+}
+
+// getOptionValue returns the option value as a string, whatever its type.
+func getOptionValue(opt *discordgo.ApplicationCommandInteractionDataOption) string {
+	switch opt.Type {
+	case discordgo.ApplicationCommandOptionString:
+		return opt.StringValue()
+	case discordgo.ApplicationCommandOptionBoolean:
+		return strconv.FormatBool(opt.BoolValue())
+	case discordgo.ApplicationCommandOptionInteger:
+		return strconv.FormatInt(opt.IntValue(), 10)
+	default:
+		return fmt.Sprint(opt.Value)
+	}
+}
+
+// getOptionsMapFromModal reads the text inputs of a submitted modal. The
+// region is carried in the modal custom ID.
+func getOptionsMapFromModal(i *discordgo.InteractionCreate) map[string]interface{} {
+	data := i.ModalSubmitData()
+	optionsMap := make(map[string]interface{})
+	optionsMap["guild_id"] = i.GuildID
+	if args := getCustomIDArgs(data.CustomID); len(args) > 0 {
+		optionsMap["region"] = args[0]
+	}
+
+	for _, c := range data.Components {
+		row, ok := c.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, rc := range row.Components {
+			if input, ok := rc.(*discordgo.TextInput); ok {
+				optionsMap[input.CustomID] = strings.TrimSpace(input.Value)
+			}
+		}
+	}
 	return optionsMap
 }
 
+func listInstances(args map[string]string) ([]Instance, error) {
+	provider, err := getProvider(args)
+	if err != nil {
+		return nil, err
+	}
+	return provider.List(context.TODO())
+}
+
 func convertMapValuesToString(input map[string]interface{}) map[string]string {
 	output := make(map[string]string)
 
@@ -268,12 +1007,199 @@ func sendMessageEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate,
 	})
 }
 
+// errorMessage is the reply shown when a handler fails with err.
+func errorMessage(err error) string {
+	if errors.Is(err, errCredentials) {
+		return "ValBot could not read the saved credentials for this region. Run `/init` again to replace them."
+	}
+	return fmt.Sprintf("Something went wrong...\n```%s```", err)
+}
+
+// sendError reports err to the member only. Interactions that were already
+// acknowledged get a followup message instead.
+func sendError(s *discordgo.Session, i *discordgo.InteractionCreate, err error) {
+	log.Println(err)
+	auditFailure(i, err)
+	content := errorMessage(err)
+	respErr := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if respErr != nil {
+		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+	}
+}
+
+// updateError reports err in the deferred response of a handler.
+func updateError(s *discordgo.Session, i *discordgo.InteractionCreate, err error) {
+	auditFailure(i, err)
+	deferMessageUpdate(s, i, errorMessage(err))
+}
+
 func deferMessage(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 	})
 }
 
+func deferMessageEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// sendInitModal asks for the AWS access of region in the modal for mode.
+func sendInitModal(s *discordgo.Session, i *discordgo.InteractionCreate, region string, mode string) {
+	if mode == authModeRole {
+		sendRoleInstructions(s, i, region)
+	} else {
+		sendCredentialsModal(s, i, region)
+	}
+}
+
+// sendReplaceConfirm asks before /init replaces the credentials already saved
+// for region, since that cuts off the servers reachable with them.
+func sendReplaceConfirm(s *discordgo.Session, i *discordgo.InteractionCreate, region string, mode string, current map[string]interface{}) {
+	content := fmt.Sprintf("ValBot is already initialized for Region: `%s`", region)
+	if updatedBy := current["updated_by"].(string); updatedBy != "" {
+		content += fmt.Sprintf(", last updated by <@%s>", updatedBy)
+		if updatedAt, err := time.Parse(time.RFC3339Nano, current["updated_at"].(string)); err == nil {
+			content += fmt.Sprintf(" <t:%d:R>", updatedAt.Unix())
+		}
+	}
+	content += fmt.Sprintf(". Replace the existing credentials? They can be restored with `/init-rollback` for %d days.", int(credentialRollbackWindow.Hours()/24))
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    "Replace",
+							Style:    discordgo.DangerButton,
+							CustomID: "init_replace:" + region + ":" + mode,
+						},
+						discordgo.Button{
+							Label:    "Cancel",
+							Style:    discordgo.SecondaryButton,
+							CustomID: "init_cancel",
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// sendCredentialsModal asks for the AWS keys of region in a modal, so they
+// never show up as slash command options.
+func sendCredentialsModal(s *discordgo.Session, i *discordgo.InteractionCreate, region string) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: "init_credentials:" + region,
+			Title:    fmt.Sprintf("AWS Credentials for %s", region),
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "aws_access_key_id",
+							Label:     "AWS Access Key ID",
+							Style:     discordgo.TextInputShort,
+							Required:  true,
+							MinLength: 16,
+							MaxLength: 128,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "aws_secret_access_key",
+							Label:     "AWS Secret Access Key",
+							Style:     discordgo.TextInputShort,
+							Required:  true,
+							MinLength: 16,
+							MaxLength: 128,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// sendRoleInstructions shows the external ID of the guild, which the trust
+// policy of the role must require, before asking for the role itself.
+func sendRoleInstructions(s *discordgo.Session, i *discordgo.InteractionCreate, region string) {
+	externalID, err := getExternalID(i.GuildID)
+	if err != nil {
+		sendError(s, i, err)
+		return
+	}
+
+	responseType := discordgo.InteractionResponseChannelMessageWithSource
+	if i.Type == discordgo.InteractionMessageComponent {
+		responseType = discordgo.InteractionResponseUpdateMessage
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: responseType,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("The trust policy of the role must allow ValBot's AWS principal to call `sts:AssumeRole` with the condition `sts:ExternalId` equal to `%s`. This external ID is the same for every role of this guild.", externalID),
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    "Enter Role ARN",
+							Style:    discordgo.PrimaryButton,
+							CustomID: "init_role_form:" + region,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// sendRoleModal asks for the IAM role ValBot should assume in region.
+func sendRoleModal(s *discordgo.Session, i *discordgo.InteractionCreate, region string) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: "init_role:" + region,
+			Title:    fmt.Sprintf("IAM Role for %s", region),
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "role_arn",
+							Label:       "Role ARN",
+							Style:       discordgo.TextInputShort,
+							Placeholder: "arn:aws:iam::123456789012:role/valbot",
+							Required:    true,
+							MinLength:   20,
+							MaxLength:   2048,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
 func deferMessageUpdate(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
 	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
 		Content: &content,
@@ -293,29 +1219,179 @@ func deferMessageStatus(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	})
 }
 
-func sendInstanceStatus(s *discordgo.Session, i *discordgo.InteractionCreate, instances []map[string]interface{}, options map[string]interface{}) {
+func sendInstanceStatus(s *discordgo.Session, i *discordgo.InteractionCreate, instances []Instance, options map[string]interface{}) {
+	format, _ := options["format"].(string)
+	region := options["region"].(string)
+	pageStr, _ := options["page"].(string)
+	page, _ := strconv.Atoi(pageStr)
+	queryInstanceGames(i.GuildID, region, instances)
+	view := renderStatus(format, region, instances, page)
 
-	table, err := stable.ToTable(instances)
-	if err != nil {
-		fmt.Println(err)
-		return
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    "refresh_status",
+					Placeholder: "Select Region to Refresh Status",
+					Options:     getRegionComponentOptions(),
+				},
+			},
+		},
+	}
+	if len(view.Shown) > 0 {
+		selected, _ := options["selected"].(string)
+		components = append(components, instanceSelect(region, view, selected))
+		var instance *Instance
+		for n := range view.Shown {
+			if view.Shown[n].ID == selected {
+				instance = &view.Shown[n]
+			}
+		}
+		components = append(components, instanceButtons(region, instance))
+	}
+	if view.Pages > 1 {
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Previous",
+					Style:    discordgo.SecondaryButton,
+					CustomID: fmt.Sprintf("status_page:%s:%d", region, view.Page-1),
+					Disabled: view.Page == 0,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					CustomID: fmt.Sprintf("status_page:%s:%d", region, view.Page+1),
+					Disabled: view.Page == view.Pages-1,
+				},
+			},
+		})
 	}
-	table.SetCaption(fmt.Sprintf("Status - %s", options["region"]))
-
-	content := fmt.Sprintf("```\n%s```", table.String())
 
 	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &content,
-		Components: &[]discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.SelectMenu{
-						CustomID:    "refresh_status",
-						Placeholder: "Select Region to Refresh Status",
-						Options:     getRegionComponentOptions(),
-					},
-				},
+		Content:    &view.Content,
+		Embeds:     &view.Embeds,
+		Files:      view.Files,
+		Components: &components,
+	})
+}
+
+// Discord rejects select option labels longer than this.
+const maxOptionLabelLength = 100
+
+// instanceSelect picks which of the instances shown by /status the action
+// buttons work on. Its custom ID is srv_select:<region>:<page>.
+func instanceSelect(region string, view statusView, selected string) discordgo.ActionsRow {
+	options := make([]discordgo.SelectMenuOption, 0, len(view.Shown))
+	for _, instance := range view.Shown {
+		label := instance.ID
+		if instance.Name != "" {
+			label = fmt.Sprintf("%s (%s)", instance.Name, instance.ID)
+		}
+		if r := []rune(label); len(r) > maxOptionLabelLength {
+			label = string(r[:maxOptionLabelLength-1]) + "…"
+		}
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       label,
+			Value:       instance.ID,
+			Description: strings.ToUpper(instance.State),
+			Emoji:       discordgo.ComponentEmoji{Name: stateEmoji(instance.State)},
+			Default:     instance.ID == selected,
+		})
+	}
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				CustomID:    fmt.Sprintf("srv_select:%s:%d", region, view.Page),
+				Placeholder: "Select a server for the buttons below",
+				Options:     options,
+			},
+		},
+	}
+}
+
+// instanceButtons are the actions of the instance selected in /status, all
+// disabled until one is. Their custom IDs are name:<region>:<instance ID>.
+func instanceButtons(region string, instance *Instance) discordgo.ActionsRow {
+	id := region + ":"
+	var state, ip string
+	if instance != nil {
+		id += instance.ID
+		state, ip = instance.State, instance.PublicIP
+	}
+	running := state == "running"
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Label:    "Start",
+				Style:    discordgo.SuccessButton,
+				CustomID: "srv_start:" + id,
+				Disabled: state != "stopped",
+			},
+			discordgo.Button{
+				Label:    "Stop",
+				Style:    discordgo.DangerButton,
+				CustomID: "srv_stop:" + id,
+				Disabled: !running,
+			},
+			discordgo.Button{
+				Label:    "Reboot",
+				Style:    discordgo.SecondaryButton,
+				CustomID: "srv_reboot:" + id,
+				Disabled: !running,
+			},
+			discordgo.Button{
+				Label:    "Connect",
+				Style:    discordgo.PrimaryButton,
+				CustomID: "srv_connect:" + id,
+				Disabled: !running || ip == "",
 			},
 		},
+	}
+}
+
+// sendConnectInfo tells the member who pressed srv_connect where to join the
+// game on the instance.
+func sendConnectInfo(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	args := getCustomIDArgs(i.MessageComponentData().CustomID)
+	if len(args) < 2 {
+		return
+	}
+	region, instanceID := args[0], args[1]
+	auditInstance(i, instanceID)
+
+	optionsMap, err := getOptionsMapWithCredsForRegion(i.GuildID, region)
+	var instance Instance
+	if err == nil {
+		var provider ServerProvider
+		provider, err = getProvider(convertMapValuesToString(optionsMap))
+		if err == nil {
+			instance, err = provider.Describe(context.TODO(), instanceID)
+		}
+	}
+	if err != nil {
+		sendError(s, i, err)
+		return
+	}
+	if instance.State != "running" || instance.PublicIP == "" {
+		sendMessageEphemeral(s, i, fmt.Sprintf("Instance `%s` in `%s` is `%s`, start it to connect.", instanceID, region, strings.ToUpper(instance.State)))
+		return
+	}
+
+	settings, err := getServerSettings(map[string]interface{}{
+		"guild_id":    i.GuildID,
+		"region":      region,
+		"instance_id": instanceID,
 	})
+	if err != nil {
+		log.Println("Error reading server settings:", err)
+	}
+	// The query port is not always the game port, so only a configured game
+	// port is shown.
+	if len(settings) == 0 || settings[0].GamePort == 0 {
+		sendMessageEphemeral(s, i, fmt.Sprintf("Connect to instance `%s` in `%s` at `%s`, on the port of the game. An admin can add the port with `/idle set game-port`.", instanceID, region, instance.PublicIP))
+		return
+	}
+	address := net.JoinHostPort(instance.PublicIP, strconv.Itoa(settings[0].GamePort))
+	sendMessageEphemeral(s, i, fmt.Sprintf("Connect to instance `%s` in `%s` at `%s`", instanceID, region, address))
 }
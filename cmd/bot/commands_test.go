@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func newTestServers() *memoryProvider {
+	return newMemoryProvider(
+		Instance{ID: "i-running", Name: "valheim", State: "running", PublicIP: "203.0.113.1", InstanceType: "t3.medium"},
+		Instance{ID: "i-stopped", Name: "minecraft", State: "stopped", InstanceType: "t3.large"},
+		Instance{ID: "i-hibernate", State: "running", Hibernation: true},
+	)
+}
+
+// lastAuditEvent returns the latest audit event of the test guild.
+func lastAuditEvent(t *testing.T) map[string]interface{} {
+	t.Helper()
+	rows, err := store.Recent(context.Background(), "audit_events", map[string]interface{}{"guild_id": testGuildID}, 1)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("audit_events = %v, %v", rows, err)
+	}
+	return rows[0]
+}
+
+func TestCommandHandlers(t *testing.T) {
+	region := stringOption("region", testRegion)
+	tests := []struct {
+		name        string
+		interaction *discordgo.InteractionCreate
+		// setup runs before the interaction, after the guild is initialized.
+		setup func(t *testing.T)
+		want  string
+		// check inspects the provider and store after the interaction.
+		check func(t *testing.T, p *memoryProvider)
+	}{
+		{
+			name:        "help",
+			interaction: commandInteraction("help"),
+			want:        "Setup Valbot with `/init`",
+		},
+		{
+			name:        "start",
+			interaction: commandInteraction("start", region, stringOption("server", "i-stopped")),
+			want:        "Starting instance `i-stopped` in `us-east-1`: `STOPPED` → `RUNNING`",
+			check: func(t *testing.T, p *memoryProvider) {
+				if state := p.state(t, "i-stopped"); state != "running" {
+					t.Errorf("state = %s, want running", state)
+				}
+			},
+		},
+		{
+			name:        "start by name",
+			interaction: commandInteraction("start", region, stringOption("server", "minecraft")),
+			want:        "`STOPPED` → `RUNNING`",
+			check: func(t *testing.T, p *memoryProvider) {
+				if got := lastAuditEvent(t)["instance_id"]; got != "i-stopped" {
+					t.Errorf("audited instance_id = %q, want the resolved i-stopped", got)
+				}
+			},
+		},
+		{
+			name:        "start unmanaged",
+			interaction: commandInteraction("start", region, stringOption("server", "i-other")),
+			want:        "instance `i-other` is not managed in `us-east-1`",
+		},
+		{
+			name:        "start unknown name",
+			interaction: commandInteraction("start", region, stringOption("server", "terraria")),
+			want:        "no server named `terraria`",
+			check: func(t *testing.T, p *memoryProvider) {
+				if got := lastAuditEvent(t)["instance_id"]; got != "" {
+					t.Errorf("audited instance_id = %q, want none for an unresolved server", got)
+				}
+			},
+		},
+		{
+			name:        "start uninitialized region",
+			interaction: commandInteraction("start", stringOption("region", "us-west-2"), stringOption("server", "i-stopped")),
+			want:        "run `/init` first",
+		},
+		{
+			name:        "start denied",
+			interaction: asMember(commandInteraction("start", region, stringOption("server", "i-stopped"))),
+			want:        "You need the `power` permission",
+			check: func(t *testing.T, p *memoryProvider) {
+				if state := p.state(t, "i-stopped"); state != "stopped" {
+					t.Errorf("state = %s, want stopped", state)
+				}
+			},
+		},
+		{
+			name:        "stop",
+			interaction: commandInteraction("stop", region, stringOption("server", "i-running"), boolOption("force", true)),
+			want:        "`RUNNING` → `STOPPED`",
+			check: func(t *testing.T, p *memoryProvider) {
+				if state := p.state(t, "i-running"); state != "stopped" {
+					t.Errorf("state = %s, want stopped", state)
+				}
+			},
+		},
+		{
+			name:        "reboot",
+			interaction: commandInteraction("reboot", region, stringOption("server", "i-running")),
+			want:        "Rebooting instance `i-running` in `us-east-1`: `RUNNING`",
+		},
+		{
+			name:        "hibernate",
+			interaction: commandInteraction("hibernate", region, stringOption("server", "i-hibernate")),
+			want:        "`RUNNING` → `STOPPED`",
+		},
+		{
+			name:        "hibernate unsupported",
+			interaction: commandInteraction("hibernate", region, stringOption("server", "i-running")),
+			want:        "not launched with hibernation enabled",
+		},
+		{
+			name:        "alias add",
+			interaction: commandInteraction("alias", subcommand("add", region, stringOption("server", "i-running"), stringOption("alias", "vh"))),
+			want:        "vh",
+			check: func(t *testing.T, p *memoryProvider) {
+				id, err := resolveInstance(p, map[string]string{"guild_id": testGuildID, "region": testRegion, "server": "vh"})
+				if err != nil || id != "i-running" {
+					t.Errorf("resolveInstance(vh) = %s, %v, want i-running", id, err)
+				}
+			},
+		},
+		{
+			name:        "servers list",
+			interaction: commandInteraction("servers", subcommand("list", region)),
+			want:        "i-stopped",
+		},
+		{
+			name:        "servers add tag",
+			interaction: commandInteraction("servers", subcommand("add", region, stringOption("tag", "game=valheim"))),
+			want:        "game=valheim",
+		},
+		{
+			name:        "permissions grant",
+			interaction: commandInteraction("permissions", subcommand("grant", stringOption("capability", capabilityPower), stringOption("user", testMemberID))),
+			want:        testMemberID,
+			check: func(t *testing.T, p *memoryProvider) {
+				i := asMember(commandInteraction("start"))
+				if ok, err := hasCapability(i, capabilityPower); !ok || err != nil {
+					t.Errorf("hasCapability(power) = %v, %v after grant", ok, err)
+				}
+			},
+		},
+		{
+			name:        "idle set",
+			interaction: commandInteraction("idle", subcommand("set", region, stringOption("server", "i-running"), stringOption("query", "a2s"), intOption("port", 2457), intOption("minutes", 30))),
+			want:        "Saved settings of `i-running`",
+		},
+		{
+			name:        "idle set game port",
+			interaction: commandInteraction("idle", subcommand("set", region, stringOption("server", "i-running"), stringOption("query", "a2s"), intOption("port", 2457), intOption("game-port", 2456))),
+			want:        "`a2s` query on port `2457`, game on port `2456`",
+		},
+		{
+			name:        "connect without game port",
+			interaction: componentInteraction("srv_connect:" + testRegion + ":i-running"),
+			setup: func(t *testing.T) {
+				err := saveServerSettings(serverSettings{GuildID: testGuildID, Region: testRegion, InstanceID: "i-running", QueryType: "a2s", QueryPort: 2457})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "at `203.0.113.1`, on the port of the game",
+		},
+		{
+			name:        "connect with game port",
+			interaction: componentInteraction("srv_connect:" + testRegion + ":i-running"),
+			setup: func(t *testing.T) {
+				err := saveServerSettings(serverSettings{GuildID: testGuildID, Region: testRegion, InstanceID: "i-running", QueryType: "a2s", QueryPort: 2457, GamePort: 2456})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "at `203.0.113.1:2456`",
+		},
+		{
+			name:        "connect stopped",
+			interaction: componentInteraction("srv_connect:" + testRegion + ":i-stopped"),
+			want:        "start it to connect",
+		},
+		{
+			name:        "idle list",
+			interaction: commandInteraction("idle", subcommand("list", region)),
+			setup: func(t *testing.T) {
+				err := saveServerSettings(serverSettings{GuildID: testGuildID, Region: testRegion, InstanceID: "i-running", QueryType: "minecraft", QueryPort: 25565})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "`i-running`: `minecraft` query on port `25565`, never stopped when idle",
+		},
+		{
+			name:        "idle list empty",
+			interaction: commandInteraction("idle", subcommand("list", region)),
+			want:        "No server settings",
+		},
+		{
+			name:        "schedule add",
+			interaction: commandInteraction("schedule", subcommand("add", region, stringOption("server", "i-stopped"), stringOption("action", scheduleStart), stringOption("cron", "0 19 * * FRI"), stringOption("timezone", "Europe/Berlin"))),
+			want:        "Scheduled start of `i-stopped`",
+		},
+		{
+			name:        "schedule add invalid cron",
+			interaction: commandInteraction("schedule", subcommand("add", region, stringOption("server", "i-stopped"), stringOption("action", scheduleStart), stringOption("cron", "every friday"))),
+			want:        "Invalid schedule",
+		},
+		{
+			name:        "audit list",
+			interaction: commandInteraction("audit", subcommand("list")),
+			want:        "No audit events found.",
+		},
+		{
+			name:        "init-delete",
+			interaction: commandInteraction("init-delete", region),
+			want:        "Deleted ValBot AWS Credentials",
+			check: func(t *testing.T, p *memoryProvider) {
+				rows, err := queryDB("guilds", map[string]interface{}{"guild_id": testGuildID})
+				if err != nil || len(rows) != 0 {
+					t.Errorf("guilds = %v, %v after init-delete", rows, err)
+				}
+			},
+		},
+		{
+			name:        "init-rollback without previous credentials",
+			interaction: commandInteraction("init-rollback", region),
+			want:        "Something went wrong",
+		},
+		{
+			name:        "init asks before replacing",
+			interaction: commandInteraction("init", region),
+			want:        "already",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestStore(t)
+			p := newTestServers()
+			useMemoryProvider(t, p)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			s, rt := newTestSession(t)
+
+			handleInteraction(s, tt.interaction)
+
+			if got := rt.lastContent(); !strings.Contains(got, tt.want) {
+				t.Errorf("content = %q, want it to contain %q", got, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, p)
+			}
+		})
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	useTestStore(t)
+	useMemoryProvider(t, newTestServers())
+	s, rt := newTestSession(t)
+
+	handleInteraction(s, commandInteraction("status", stringOption("region", testRegion)))
+
+	msg := rt.lastMessage()
+	embeds, _ := msg["embeds"].([]interface{})
+	if len(embeds) != 1 {
+		t.Fatalf("embeds = %v, want one", msg["embeds"])
+	}
+	embed := embeds[0].(map[string]interface{})
+	if title := embed["title"]; title != "Status - us-east-1" {
+		t.Errorf("title = %v", title)
+	}
+	if fields, _ := embed["fields"].([]interface{}); len(fields) != 3 {
+		t.Errorf("fields = %v, want 3", fields)
+	}
+	// The region select, the instance select and the action buttons.
+	if components, _ := msg["components"].([]interface{}); len(components) != 3 {
+		t.Errorf("components = %v, want 3 rows", components)
+	}
+	for _, b := range statusButtons(t, msg) {
+		if !b.disabled {
+			t.Errorf("button %s is enabled before a server is selected", b.customID)
+		}
+	}
+}
+
+type testButton struct {
+	customID string
+	disabled bool
+}
+
+// statusButtons returns the action buttons of a /status message.
+func statusButtons(t *testing.T, msg map[string]interface{}) []testButton {
+	t.Helper()
+	var buttons []testButton
+	for _, row := range msg["components"].([]interface{}) {
+		for _, c := range row.(map[string]interface{})["components"].([]interface{}) {
+			c := c.(map[string]interface{})
+			id, _ := c["custom_id"].(string)
+			if strings.HasPrefix(id, "srv_") && c["type"] == float64(discordgo.ButtonComponent) {
+				disabled, _ := c["disabled"].(bool)
+				buttons = append(buttons, testButton{customID: id, disabled: disabled})
+			}
+		}
+	}
+	if len(buttons) != 4 {
+		t.Fatalf("buttons = %v, want Start, Stop, Reboot and Connect", buttons)
+	}
+	return buttons
+}
+
+func TestStatusSelectServer(t *testing.T) {
+	useTestStore(t)
+	useMemoryProvider(t, newTestServers())
+	s, rt := newTestSession(t)
+
+	handleInteraction(s, componentInteraction("srv_select:"+testRegion+":0", "i-stopped"))
+	want := []testButton{
+		{customID: "srv_start:us-east-1:i-stopped"},
+		{customID: "srv_stop:us-east-1:i-stopped", disabled: true},
+		{customID: "srv_reboot:us-east-1:i-stopped", disabled: true},
+		{customID: "srv_connect:us-east-1:i-stopped", disabled: true},
+	}
+	got := statusButtons(t, rt.lastMessage())
+	for n := range want {
+		if got[n] != want[n] {
+			t.Errorf("button %d = %+v, want %+v", n, got[n], want[n])
+		}
+	}
+}
+
+func TestStatusPages(t *testing.T) {
+	useTestStore(t)
+	var instances []Instance
+	for n := 0; n < 12; n++ {
+		instances = append(instances, Instance{ID: fmt.Sprintf("i-%02d", n), State: "stopped"})
+	}
+	useMemoryProvider(t, newMemoryProvider(instances...))
+	s, rt := newTestSession(t)
+
+	handleInteraction(s, componentInteraction("status_page:"+testRegion+":1"))
+
+	msg := rt.lastMessage()
+	embed := msg["embeds"].([]interface{})[0].(map[string]interface{})
+	if fields := embed["fields"].([]interface{}); len(fields) != 3 {
+		t.Errorf("fields = %d, want the 3 instances of the last page", len(fields))
+	}
+	if footer := embed["footer"].(map[string]interface{})["text"]; footer != "Page 2 of 2, 12 instances" {
+		t.Errorf("footer = %v", footer)
+	}
+	// The region select, the instance select, the action buttons and the page buttons.
+	if components := msg["components"].([]interface{}); len(components) != 4 {
+		t.Errorf("components = %d rows, want 4", len(components))
+	}
+}
+
+func TestStatusUsesAllowlist(t *testing.T) {
+	useTestStore(t)
+	p := newTestServers()
+	useMemoryProvider(t, p)
+	_, err := deleteDB("guild_servers", map[string]interface{}{"value": "i-stopped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := listInstances(map[string]string{"guild_id": testGuildID, "region": testRegion, "provider": "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, instance := range instances {
+		if instance.ID == "i-stopped" {
+			t.Error("unmanaged instance listed")
+		}
+	}
+	if _, err := p.Describe(context.Background(), "i-stopped"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitRoleShowsExternalID(t *testing.T) {
+	useTestStore(t)
+	s, rt := newTestSession(t)
+	externalID, err := getExternalID(testGuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handleInteraction(s, commandInteraction("init", stringOption("region", testRegion), stringOption("mode", authModeRole)))
+	if got := rt.lastContent(); !strings.Contains(got, externalID) {
+		t.Errorf("content = %q, want the external ID %q", got, externalID)
+	}
+
+	handleInteraction(s, componentInteraction("init_role_form:"+testRegion))
+	modal := rt.lastMessage()
+	if modal["custom_id"] != "init_role:"+testRegion {
+		t.Fatalf("response = %v, want the role modal", modal)
+	}
+	var inputs []string
+	for _, row := range modal["components"].([]interface{}) {
+		for _, c := range row.(map[string]interface{})["components"].([]interface{}) {
+			inputs = append(inputs, c.(map[string]interface{})["custom_id"].(string))
+		}
+	}
+	if len(inputs) != 1 || inputs[0] != "role_arn" {
+		t.Errorf("modal inputs = %v, want only role_arn", inputs)
+	}
+}
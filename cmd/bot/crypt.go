@@ -1,83 +1,261 @@
 package main
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"strings"
 )
 
-var key []byte
+// Credentials are encrypted with a random data key per guilds row. The data key
+// is stored next to them in the data_key column, wrapped by a KeyWrapper so the
+// master key can live outside of the bot (see keywrap.go).
 
-func initCryptKey(cryptKey string) {
-	if cryptKey == "" {
-		log.Fatalln("Error crypt key not specified")
+// keyring holds every AES key ValBot knows about, by key ID. New ciphertexts
+// are always sealed with the current key, older ones with any known key.
+type keyring struct {
+	keys    map[string][]byte
+	order   []string
+	current string
+}
+
+// keys is the keyring from VBOT_AES_KEY. It backs the local key wrapper and
+// decrypts rows written before envelope encryption. It is nil if not set.
+var keys *keyring
+
+// parseKeyring reads a comma separated list of "id:hexkey" entries, the last one
+// being the current key. A single bare hex key is accepted as key ID "1".
+func parseKeyring(cryptKey string) (*keyring, error) {
+	kr := &keyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(cryptKey, ",") {
+		entry = strings.TrimSpace(entry)
+		id, hexKey, ok := strings.Cut(entry, ":")
+		if !ok {
+			id, hexKey = "1", entry
+		}
+		if id == "" {
+			return nil, fmt.Errorf("empty key ID in %q", entry)
+		}
+		if _, exists := kr.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key ID %q", id)
+		}
+
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		kr.keys[id] = key
+		kr.order = append(kr.order, id)
+		kr.current = id
+	}
+	return kr, nil
+}
+
+func initCryptKey(cryptKey string, kms string) error {
+	if cryptKey == "" && kms == "" {
+		return errors.New("crypt key not specified")
 	}
 
 	var err error
-	key, err = hex.DecodeString(cryptKey)
+	if cryptKey != "" {
+		keys, err = parseKeyring(cryptKey)
+		if err != nil {
+			return fmt.Errorf("parsing crypt key: %w", err)
+		}
+	}
+
+	keyWrapper, err = newKeyWrapper(kms)
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("configuring key wrapper: %w", err)
 	}
+	return nil
 }
 
-func encrypt(plainString string) string {
-	bytes := []byte(plainString)
-
+// seal encrypts plaintext with key, prefixing the result with the nonce. The
+// additional data is authenticated but not stored, open must be given the same.
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
 	//Create a new Cipher Block from the key
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	//Create a new GCM - https://en.wikipedia.org/wiki/Galois/Counter_Mode
 	//https://golang.org/pkg/crypto/cipher/#NewGCM
 	aesGCM, err := cipher.NewGCM(block)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	//Create a nonce. Nonce should be from GCM
 	nonce := make([]byte, aesGCM.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	//Encrypt the data using aesGCM.Seal
 	//Since we don't want to save the nonce somewhere else in this case, we add it as a prefix to the encrypted data. The first nonce argument in Seal is the prefix.
-	ciphertext := aesGCM.Seal(nonce, nonce, bytes, nil)
-	return fmt.Sprintf("%x", ciphertext)
+	return aesGCM.Seal(nonce, nonce, plaintext, additionalData), nil
 }
 
-func decrypt(encryptedString string) string {
-	bytes, _ := hex.DecodeString(encryptedString)
-
+// open decrypts ciphertext sealed by seal.
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
 	//Create a new Cipher Block from the key
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	//Create a new GCM
 	aesGCM, err := cipher.NewGCM(block)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	//Get the nonce size
 	nonceSize := aesGCM.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
 
 	//Extract the nonce from the encrypted data
-	nonce, ciphertext := bytes[:nonceSize], bytes[nonceSize:]
+	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
 	//Decrypt the data
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	return aesGCM.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// seal encrypts plaintext with the current key. The result is prefixed with
+// the key ID, e.g. "2:9f86d0...".
+func (kr *keyring) seal(plaintext []byte) (string, error) {
+	ciphertext, err := seal(kr.keys[kr.current], plaintext, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%x", kr.current, ciphertext), nil
+}
+
+// open decrypts a ciphertext from seal. Ciphertexts without a key ID were
+// written before key IDs existed and are tried with every key.
+func (kr *keyring) open(encryptedString string) ([]byte, error) {
+	id, hexString, versioned := strings.Cut(encryptedString, ":")
+	if !versioned {
+		hexString = encryptedString
+	}
+	bytes, err := hex.DecodeString(hexString)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := kr.order
+	if versioned {
+		if _, ok := kr.keys[id]; !ok {
+			return nil, fmt.Errorf("unknown crypt key ID %q", id)
+		}
+		candidates = []string{id}
+	}
+
+	err = errors.New("no crypt keys")
+	for _, id := range candidates {
+		var plaintext []byte
+		plaintext, err = open(kr.keys[id], bytes, nil)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
+
+// newDataKey creates a random data key for a guilds row, along with its
+// wrapped form for the data_key column.
+func newDataKey(ctx context.Context) ([]byte, string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", err
+	}
+	wrapped, err := keyWrapper.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, keyWrapper.Name() + ":" + wrapped, nil
+}
+
+// unwrapDataKey recovers the data key of a guilds row from its data_key column.
+func unwrapDataKey(ctx context.Context, wrapped string) ([]byte, error) {
+	name, wrapped, _ := strings.Cut(wrapped, ":")
+	if name != keyWrapper.Name() {
+		w, err := newKeyWrapperFor(name)
+		if err != nil {
+			return nil, fmt.Errorf("data key was wrapped by %q: %w", name, err)
+		}
+		return w.Unwrap(ctx, wrapped)
+	}
+	return keyWrapper.Unwrap(ctx, wrapped)
+}
+
+// Credentials are bound to the guild, region and column they were saved for,
+// so a value copied into another row fails to decrypt. Bound values carry this
+// prefix, values without it were written before and are read without binding.
+const boundPrefix = "v2:"
+
+// requireBound rejects values that are not bound to their row. Turn it on
+// once `bot reencrypt` has rewritten every row.
+var requireBound bool
+
+// credentialAD is the additional data binding a value to its guilds row and column.
+func credentialAD(guildID string, region string, column string) []byte {
+	return []byte(strings.Join([]string{guildID, region, column}, "\x00"))
+}
+
+// errCredentials is returned when stored credentials can't be decrypted.
+var errCredentials = errors.New("the stored credentials could not be decrypted, an admin may need to run `/init` again")
+
+// encrypt seals plainString with a row data key, bound to additionalData.
+func encrypt(dataKey []byte, plainString string, additionalData []byte) (string, error) {
+	ciphertext, err := seal(dataKey, []byte(plainString), additionalData)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%x", boundPrefix, ciphertext), nil
+}
+
+// decrypt opens a value sealed by encrypt. Rows without a data key were
+// written before envelope encryption and are opened with the keyring.
+func decrypt(dataKey []byte, encryptedString string, additionalData []byte) (string, error) {
+	bound := strings.HasPrefix(encryptedString, boundPrefix)
+	hexString := strings.TrimPrefix(encryptedString, boundPrefix)
+	if !bound {
+		if requireBound {
+			return "", fmt.Errorf("%w: not bound to their guild, run `bot reencrypt`", errCredentials)
+		}
+		additionalData = nil
+	}
+
+	var plaintext []byte
+	var err error
+	if dataKey == nil {
+		if keys == nil {
+			return "", fmt.Errorf("%w: encrypted with VBOT_AES_KEY, which is not set", errCredentials)
+		}
+		plaintext, err = keys.open(hexString)
+	} else {
+		var bytes []byte
+		bytes, err = hex.DecodeString(hexString)
+		if err == nil {
+			plaintext, err = open(dataKey, bytes, additionalData)
+		}
+	}
 	if err != nil {
-		panic(err.Error())
+		return "", fmt.Errorf("%w: %v", errCredentials, err)
 	}
 
-	return string(plaintext)
+	return string(plaintext), nil
 }
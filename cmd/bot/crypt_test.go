@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// useKeyring makes cryptKey the VBOT_AES_KEY keyring and the local key wrapper
+// for the test.
+func useKeyring(t *testing.T, cryptKey string) {
+	t.Helper()
+	kr, err := parseKeyring(cryptKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := keys
+	keys = kr
+	t.Cleanup(func() { keys = previous })
+	useKeyWrapper(t, &localKeyWrapper{keys: kr})
+}
+
+// insertLegacyCreds stores credentials the way they were saved before data
+// keys, sealed with the keyring under the key ID it prefixes them with.
+func insertLegacyCreds(t *testing.T, region string, accessKey string, secretKey string) {
+	t.Helper()
+	row := map[string]interface{}{
+		"guild_id":   testGuildID,
+		"region":     region,
+		"auth_mode":  authModeKeys,
+		"updated_by": testAdminID,
+	}
+	for column, v := range map[string]string{"aws_access_key_id": accessKey, "aws_secret_access_key": secretKey} {
+		enc, err := keys.seal([]byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		row[column] = enc
+	}
+	if err := store.Insert(context.Background(), "guilds", row); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	useTestStore(t)
+	useKeyring(t, "1:"+testKey1)
+	insertLegacyCreds(t, testRegion, "AKIAOLD", "old-secret")
+
+	// Adding a key leaves the ciphertexts of the old one readable.
+	useKeyring(t, "1:"+testKey1+",2:"+testKey2)
+	rows, err := getCredsFromDB(map[string]interface{}{"guild_id": testGuildID, "region": testRegion})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("getCredsFromDB() = %v, %v", rows, err)
+	}
+	if got := rows[0]["aws_secret_access_key"]; got != "old-secret" {
+		t.Errorf("aws_secret_access_key = %v, want old-secret", got)
+	}
+	if enc, err := keys.seal([]byte("new")); err != nil || !strings.HasPrefix(enc, "2:") {
+		t.Errorf("seal() = %q, %v, want it sealed with key 2", enc, err)
+	}
+
+	// Dropping the key makes them fail with the credentials error.
+	useKeyring(t, "2:"+testKey2)
+	_, err = getCredsFromDB(map[string]interface{}{"guild_id": testGuildID, "region": testRegion})
+	if !errors.Is(err, errCredentials) || !strings.Contains(err.Error(), `unknown crypt key ID "1"`) {
+		t.Errorf("getCredsFromDB() error = %v, want the unknown key ID", err)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	st := useTestStore(t)
+	ctx := context.Background()
+	useKeyring(t, "1:"+testKey1)
+	insertLegacyCreds(t, testRegion, "AKIAEAST", "east-secret")
+	if err := saveCredsToDB(map[string]interface{}{
+		"guild_id":              testGuildID,
+		"region":                "us-west-2",
+		"auth_mode":             authModeKeys,
+		"aws_access_key_id":     "AKIAWEST",
+		"aws_secret_access_key": "west-secret",
+		"updated_by":            testAdminID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	useKeyring(t, "1:"+testKey1+",2:"+testKey2)
+	if err := reencryptCmd(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// With key 1 gone, every row still opens.
+	useKeyring(t, "2:"+testKey2)
+	rows, err := st.Query(ctx, "guilds", nil)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("guilds = %v, %v", rows, err)
+	}
+	want := map[string]string{testRegion: "east-secret", "us-west-2": "west-secret"}
+	for _, row := range rows {
+		if key := row["data_key"].(string); !strings.HasPrefix(key, "local:2:") {
+			t.Errorf("%s data_key = %q, want it wrapped with key 2", row["region"], key)
+		}
+		creds, err := getCredsFromDB(map[string]interface{}{"guild_id": testGuildID, "region": row["region"]})
+		if err != nil || len(creds) != 1 {
+			t.Fatalf("getCredsFromDB(%s) = %v, %v", row["region"], creds, err)
+		}
+		if got := creds[0]["aws_secret_access_key"]; got != want[row["region"].(string)] {
+			t.Errorf("%s aws_secret_access_key = %v, want %s", row["region"], got, want[row["region"].(string)])
+		}
+	}
+}
+
+func TestReencryptRollsBack(t *testing.T) {
+	st := useTestStore(t)
+	ctx := context.Background()
+	useKeyring(t, "1:"+testKey1)
+	insertLegacyCreds(t, testRegion, "AKIAEAST", "east-secret")
+	insertLegacyCreds(t, "us-west-2", "AKIAWEST", "west-secret")
+	// A value no key opens fails the run, whichever row comes first.
+	if _, err := st.Update(ctx, "guilds", map[string]interface{}{"region": "us-west-2"}, map[string]interface{}{"aws_secret_access_key": "9:00"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := st.Query(ctx, "guilds", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useKeyring(t, "1:"+testKey1+",2:"+testKey2)
+	if err := reencryptCmd(nil); err == nil {
+		t.Fatal("reencryptCmd() with an unreadable row succeeded")
+	}
+	after, err := st.Query(ctx, "guilds", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range before {
+		for _, column := range []string{"data_key", "aws_access_key_id", "aws_secret_access_key"} {
+			if after[n][column] != before[n][column] {
+				t.Errorf("%s %s = %q, want it left at %q", before[n]["region"], column, after[n][column], before[n][column])
+			}
+		}
+	}
+}
+
+// useRequireBound sets requireBound for the test.
+func useRequireBound(t *testing.T, require bool) {
+	t.Helper()
+	previous := requireBound
+	requireBound = require
+	t.Cleanup(func() { requireBound = previous })
+}
+
+func TestDecryptBinding(t *testing.T) {
+	dataKey := make([]byte, 32)
+	sealedFor := credentialAD(testGuildID, testRegion, "aws_secret_access_key")
+	bound, err := encrypt(dataKey, "secret", sealedFor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(bound, boundPrefix) {
+		t.Fatalf("encrypt() = %q, want it prefixed with %s", bound, boundPrefix)
+	}
+	// Values written before binding were sealed without additional data.
+	unsealed, err := seal(dataKey, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := hex.EncodeToString(unsealed)
+
+	tests := []struct {
+		name         string
+		enc          string
+		ad           []byte
+		requireBound bool
+		wantErr      bool
+	}{
+		{name: "same row", enc: bound, ad: sealedFor},
+		{name: "other guild", enc: bound, ad: credentialAD("other", testRegion, "aws_secret_access_key"), wantErr: true},
+		{name: "other region", enc: bound, ad: credentialAD(testGuildID, "us-west-2", "aws_secret_access_key"), wantErr: true},
+		{name: "other column", enc: bound, ad: credentialAD(testGuildID, testRegion, "aws_access_key_id"), wantErr: true},
+		{name: "bound required", enc: bound, ad: sealedFor, requireBound: true},
+		{name: "legacy", enc: legacy, ad: sealedFor},
+		{name: "legacy with bound required", enc: legacy, ad: sealedFor, requireBound: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRequireBound(t, tt.requireBound)
+			got, err := decrypt(dataKey, tt.enc, tt.ad)
+			switch {
+			case tt.wantErr && !errors.Is(err, errCredentials):
+				t.Errorf("decrypt() = %q, %v, want the credentials error", got, err)
+			case !tt.wantErr && (err != nil || got != "secret"):
+				t.Errorf("decrypt() = %q, %v, want secret", got, err)
+			}
+		})
+	}
+}
+
+func TestReencryptBindsLegacyValues(t *testing.T) {
+	st := useTestStore(t)
+	insertLegacyCreds(t, testRegion, "AKIAEAST", "east-secret")
+
+	if err := reencryptCmd(nil); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := st.Query(context.Background(), "guilds", nil)
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("guilds = %v, %v", rows, err)
+	}
+	for _, column := range []string{"aws_access_key_id", "aws_secret_access_key"} {
+		if v := rows[0][column].(string); !strings.HasPrefix(v, boundPrefix) {
+			t.Errorf("%s = %q, want it bound", column, v)
+		}
+	}
+
+	useRequireBound(t, true)
+	creds, err := getCredsFromDB(map[string]interface{}{"guild_id": testGuildID, "region": testRegion})
+	if err != nil || len(creds) != 1 {
+		t.Fatalf("getCredsFromDB() = %v, %v", creds, err)
+	}
+	if got := creds[0]["aws_access_key_id"]; got != "AKIAEAST" {
+		t.Errorf("aws_access_key_id = %v, want AKIAEAST", got)
+	}
+}
@@ -1,167 +1,238 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
-
-	_ "github.com/lib/pq"
+	"time"
 )
 
-var db *sql.DB
+var store GuildStore
 var sensitiveKeys = map[string]bool{"aws_access_key_id": true, "aws_secret_access_key": true}
 
-func initDB(connStr string) {
-
+func initDB(connStr string) error {
 	var err error
-	db, err = sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	err = db.Ping()
-	if err != nil {
-		panic(err)
-	}
-	sqlTable := `
-	CREATE TABLE IF NOT EXISTS guilds (
-		id SERIAL PRIMARY KEY,
-		guild_id TEXT NOT NULL,
-		region TEXT NOT NULL,
-		aws_access_key_id TEXT,
-		aws_secret_access_key TEXT,
-		UNIQUE (guild_id, region)
-	);`
-
-	_, err = db.Exec(sqlTable)
-	if err != nil {
-		panic(err)
-	}
-
+	store, err = openGuildStore(connStr)
+	return err
 }
 
-func queryDB(args map[string]interface{}) []map[string]interface{} {
-
-	sqlWhere := make([]string, 0, len(args))
-	sqlArgs := make([]interface{}, 0, len(args))
-	i := 1
-	for k, v := range args {
-		sqlWhere = append(sqlWhere, fmt.Sprintf("%s = $%s", k, strconv.Itoa(i)))
-		sqlArgs = append(sqlArgs, v)
-		i += 1
-	}
-
-	sqlStatement := fmt.Sprintf("SELECT * FROM guilds WHERE %s;", strings.Join(sqlWhere, " AND "))
-
-	rows, _ := db.Query(sqlStatement, sqlArgs...)
-	if rows == nil {
-		return nil
-	}
-	cols, _ := rows.Columns()
-
-	defer rows.Close()
-	var data []map[string]interface{}
-
-	for rows.Next() {
-		entry := make(map[string]interface{})
-		columns := make([]string, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range columns {
-			columnPointers[i] = &columns[i]
-		}
-
-		rows.Scan(columnPointers...)
-
-		for i, colName := range cols {
-			entry[colName] = columns[i]
-		}
-		data = append(data, entry)
+func queryDB(table string, args map[string]interface{}) ([]map[string]interface{}, error) {
+	data, err := store.Query(context.TODO(), table, args)
+	if err != nil {
+		log.Println(err)
 	}
-
-	return data
+	return data, err
 }
 
-func insertDB(args map[string]interface{}) error {
-
-	sqlCols := make([]string, 0, len(args))
-	sqlVals := make([]string, 0, len(args))
-	sqlArgs := make([]interface{}, 0, len(args))
-
-	i := 1
-	for k, v := range args {
-		sqlCols = append(sqlCols, k)
-		sqlVals = append(sqlVals, "$"+strconv.Itoa(i))
-		sqlArgs = append(sqlArgs, v)
-		i += 1
+func insertDB(table string, args map[string]interface{}) error {
+	err := store.Insert(context.TODO(), table, args)
+	if err != nil {
+		log.Println(err)
 	}
+	return err
+}
 
-	sqlStatement := fmt.Sprintf("INSERT INTO guilds (%s) VALUES (%s) RETURNING id;", strings.Join(sqlCols, ", "), strings.Join(sqlVals, ", "))
-
-	var id int
-	err := db.QueryRow(sqlStatement, sqlArgs...).Scan(&id)
+func deleteDB(table string, args map[string]interface{}) (int64, error) {
+	count, err := store.Delete(context.TODO(), table, args)
 	if err != nil {
 		log.Println(err)
 	}
-
-	return err
+	return count, err
 }
 
-func deleteDB(args map[string]interface{}) {
+// credentialColumns are the guilds columns /init replaces together. The
+// replaced values are kept in previous_<column> for credentialRollbackWindow.
+var credentialColumns = []string{"auth_mode", "aws_access_key_id", "aws_secret_access_key", "role_arn", "data_key", "updated_by", "updated_at"}
 
-	sqlWhere := make([]string, 0, len(args))
-	sqlArgs := make([]interface{}, 0, len(args))
+// How long replaced credentials can be restored with /init-rollback.
+const credentialRollbackWindow = 7 * 24 * time.Hour
 
-	i := 1
-	for k, v := range args {
-		sqlWhere = append(sqlWhere, fmt.Sprintf("%s = $%s", k, strconv.Itoa(i)))
-		sqlArgs = append(sqlArgs, v)
-		i += 1
+// nullIfEmpty stores empty values, and the number 0, as NULL, like columns
+// that were never set.
+func nullIfEmpty(v interface{}) interface{} {
+	if v == nil || v == "" || v == 0 {
+		return nil
 	}
+	return v
+}
 
-	sqlStatement := fmt.Sprintf("DELETE FROM guilds WHERE %s;", strings.Join(sqlWhere, " AND "))
-
-	res, err := db.Exec(sqlStatement, sqlArgs...)
+// credentialsExpired reports whether the previous credentials of row are past
+// the rollback window, counted from when they were replaced.
+func credentialsExpired(row map[string]interface{}) bool {
+	updatedAt, err := time.Parse(time.RFC3339Nano, row["updated_at"].(string))
 	if err != nil {
-		log.Println(err)
+		return true
 	}
-	fmt.Println("Result", res)
+	return time.Since(updatedAt) > credentialRollbackWindow
 }
 
+// saveCredsToDB stores the credentials in args for their guild region,
+// replacing and keeping aside any credentials already saved there.
 func saveCredsToDB(args map[string]interface{}) error {
-	encryptMap := make(map[string]interface{}, len(args))
+	ctx := context.TODO()
+	dataKey, wrappedKey, err := newDataKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	encryptMap := make(map[string]interface{}, len(args)+2)
+	encryptMap["data_key"] = wrappedKey
+	encryptMap["updated_at"] = time.Now().UTC()
 
 	for k, v := range args {
 		switch {
 		case sensitiveKeys[k]:
-			encryptMap[k] = encrypt(v.(string))
+			encryptMap[k], err = encrypt(dataKey, v.(string), credentialAD(args["guild_id"].(string), args["region"].(string), k))
+			if err != nil {
+				return err
+			}
 		default:
 			encryptMap[k] = v
 		}
 	}
-	err := insertDB(encryptMap)
 
-	return err
+	return store.Tx(ctx, func(tx GuildStore) error {
+		current, err := tx.Query(ctx, "guilds", map[string]interface{}{
+			"guild_id": args["guild_id"],
+			"region":   args["region"],
+		})
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return tx.Insert(ctx, "guilds", encryptMap)
+		}
+
+		values := make(map[string]interface{}, 2*len(credentialColumns))
+		for _, col := range credentialColumns {
+			values[col] = nullIfEmpty(encryptMap[col])
+			values["previous_"+col] = nullIfEmpty(current[0][col])
+		}
+		_, err = tx.Update(ctx, "guilds", map[string]interface{}{"id": current[0]["id"]}, values)
+		return err
+	})
 }
 
-func getCredsFromDB(args map[string]interface{}) []map[string]interface{} {
+// rollbackCredsInDB swaps the credentials of the guild region in args with the
+// ones they replaced, so running it twice undoes the rollback. It returns who
+// saved the restored credentials.
+func rollbackCredsInDB(args map[string]interface{}) (string, error) {
+	ctx := context.TODO()
+	var restoredBy string
+	err := store.Tx(ctx, func(tx GuildStore) error {
+		current, err := tx.Query(ctx, "guilds", map[string]interface{}{
+			"guild_id": args["guild_id"],
+			"region":   args["region"],
+		})
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return fmt.Errorf("ValBot is not initialized for region `%s`", args["region"])
+		}
+		row := current[0]
+		if row["previous_auth_mode"] == "" {
+			return fmt.Errorf("there are no previous credentials for region `%s` to restore", args["region"])
+		}
+		if credentialsExpired(row) {
+			return fmt.Errorf("the previous credentials for region `%s` are older than %d days and can't be restored", args["region"], int(credentialRollbackWindow.Hours()/24))
+		}
+
+		values := make(map[string]interface{}, 2*len(credentialColumns))
+		for _, col := range credentialColumns {
+			values[col] = nullIfEmpty(row["previous_"+col])
+			values["previous_"+col] = nullIfEmpty(row[col])
+		}
+		// The rollback itself is the latest change.
+		values["updated_by"] = args["updated_by"]
+		values["updated_at"] = time.Now().UTC()
+		restoredBy = row["previous_updated_by"].(string)
+
+		_, err = tx.Update(ctx, "guilds", map[string]interface{}{"id": row["id"]}, values)
+		return err
+	})
+	return restoredBy, err
+}
+
+// purgeExpiredCreds drops replaced credentials once they are past the
+// rollback window and returns how many guild regions had some.
+func purgeExpiredCreds() (int, error) {
+	ctx := context.TODO()
+	var count int
+	err := store.Tx(ctx, func(tx GuildStore) error {
+		guilds, err := tx.Query(ctx, "guilds", nil)
+		if err != nil {
+			return err
+		}
+		for _, g := range guilds {
+			if g["previous_auth_mode"] == "" || !credentialsExpired(g) {
+				continue
+			}
+			values := make(map[string]interface{}, len(credentialColumns))
+			for _, col := range credentialColumns {
+				values["previous_"+col] = nil
+			}
+			if _, err := tx.Update(ctx, "guilds", map[string]interface{}{"id": g["id"]}, values); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// purgeExpiredCredsEvery runs purgeExpiredCreds now and then every interval.
+func purgeExpiredCredsEvery(interval time.Duration) {
+	for {
+		count, err := purgeExpiredCreds()
+		if err != nil {
+			log.Println("Error purging replaced credentials:", err)
+		} else if count > 0 {
+			log.Printf("Purged replaced credentials of %d guild regions", count)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func getCredsFromDB(args map[string]interface{}) ([]map[string]interface{}, error) {
 	queryMap := make(map[string]interface{})
 	for k, v := range args {
 		if k == "guild_id" || k == "region" {
 			queryMap[k] = v
 		}
 	}
-	data := queryDB(queryMap)
+	data, err := queryDB("guilds", queryMap)
+	if err != nil {
+		return nil, err
+	}
 
-	returnData := make([]map[string]interface{}, len(data))
+	returnData := make([]map[string]interface{}, 0, len(data))
 
 	for _, d := range data {
+		var dataKey []byte
+		if wrappedKey := d["data_key"].(string); wrappedKey != "" {
+			dataKey, err = unwrapDataKey(context.TODO(), wrappedKey)
+			if err != nil {
+				log.Printf("Error unwrapping data key for guild %s region %s: %v", d["guild_id"], d["region"], err)
+				return nil, fmt.Errorf("%w: %v", errCredentials, err)
+			}
+		}
+
 		decryptMap := make(map[string]interface{}, len(d))
 
 		for k, v := range d {
 			switch {
-			case sensitiveKeys[k]:
-				decryptMap[k] = decrypt(v.(string))
+			case strings.HasPrefix(k, "previous_"):
+				// Replaced credentials are only read by /init-rollback.
+				continue
+			case sensitiveKeys[k] && v != "":
+				decryptMap[k], err = decrypt(dataKey, v.(string), credentialAD(d["guild_id"].(string), d["region"].(string), k))
+				if err != nil {
+					log.Printf("Error decrypting %s for guild %s region %s: %v", k, d["guild_id"], d["region"], err)
+					return nil, err
+				}
 			default:
 				decryptMap[k] = v
 			}
@@ -169,5 +240,5 @@ func getCredsFromDB(args map[string]interface{}) []map[string]interface{} {
 		returnData = append(returnData, decryptMap)
 	}
 
-	return returnData
+	return returnData, nil
 }
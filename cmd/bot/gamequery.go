@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ServerInfo is what a game server reports about itself.
+type ServerInfo struct {
+	Name string `json:"name"`
+	Map  string `json:"map"`
+	// Version is the version of the game the server runs, when it reports one.
+	Version string `json:"version,omitempty"`
+	// Players doesn't count bots.
+	Players    int `json:"players"`
+	MaxPlayers int `json:"max_players"`
+	// PlayerNames may be empty even with players, not every game lists them.
+	PlayerNames []string `json:"player_names,omitempty"`
+}
+
+// GameQuery asks the game server listening on addr (host:port) about itself.
+type GameQuery interface {
+	Query(ctx context.Context, addr string) (ServerInfo, error)
+}
+
+// gameQueries maps the query type configured with /idle to its implementation.
+var gameQueries = map[string]GameQuery{
+	"a2s":       a2sQuery{},
+	"minecraft": minecraftQuery{},
+}
+
+func getGameQueryNames() []string {
+	names := make([]string, 0, len(gameQueries))
+	for name := range gameQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getGameQueryChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+
+	for _, name := range getGameQueryNames() {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  name,
+			Value: name,
+		})
+	}
+	return choices
+}
+
+// How long a game server has to answer a query.
+const gameQueryTimeout = 5 * time.Second
+
+// queryGameServer asks the game server on host and port about itself using
+// the query type configured for it.
+func queryGameServer(ctx context.Context, queryType string, host string, port int) (ServerInfo, error) {
+	q, ok := gameQueries[queryType]
+	if !ok {
+		return ServerInfo{}, fmt.Errorf("unknown game query `%s`, expected one of: %s", queryType, strings.Join(getGameQueryNames(), ", "))
+	}
+	if host == "" {
+		return ServerInfo{}, errors.New("instance has no public IP to query")
+	}
+	ctx, cancel := context.WithTimeout(ctx, gameQueryTimeout)
+	defer cancel()
+	return q.Query(ctx, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// queryInstanceGames fills in the game of the running instances that have a
+// query port configured in the guild region. Servers that don't answer are
+// left without one.
+func queryInstanceGames(guildID string, region string, instances []Instance) {
+	settings, err := getServerSettings(map[string]interface{}{
+		"guild_id": guildID,
+		"region":   region,
+	})
+	if err != nil {
+		log.Println("Error reading server settings:", err)
+		return
+	}
+	byInstance := make(map[string]serverSettings, len(settings))
+	for _, st := range settings {
+		byInstance[st.InstanceID] = st
+	}
+
+	var wg sync.WaitGroup
+	for n := range instances {
+		st, ok := byInstance[instances[n].ID]
+		if !ok || instances[n].State != "running" {
+			continue
+		}
+		wg.Add(1)
+		go func(instance *Instance, st serverSettings) {
+			defer wg.Done()
+			info, err := queryGameServer(context.TODO(), st.QueryType, instance.PublicIP, st.QueryPort)
+			if err != nil {
+				log.Printf("Error querying the game on %s in %s: %v", instance.ID, region, err)
+				return
+			}
+			instance.Game = &info
+		}(&instances[n], st)
+	}
+	wg.Wait()
+}
+
+// serverSettings is how a guild configured one of its instances with /idle.
+type serverSettings struct {
+	GuildID    string
+	Region     string
+	InstanceID string
+	QueryType  string
+	QueryPort  int
+	// GamePort is the port players connect to, 0 when unknown.
+	GamePort int
+	// IdleMinutes is how long the server may go without players before it
+	// is stopped. 0 never stops it.
+	IdleMinutes int
+	// ChannelID is where idle warnings are posted.
+	ChannelID string
+}
+
+func newServerSettingsFromRow(row map[string]interface{}) serverSettings {
+	port, _ := strconv.Atoi(row["query_port"].(string))
+	gamePort, _ := strconv.Atoi(row["game_port"].(string))
+	minutes, _ := strconv.Atoi(row["idle_minutes"].(string))
+	return serverSettings{
+		GuildID:     row["guild_id"].(string),
+		Region:      row["region"].(string),
+		InstanceID:  row["instance_id"].(string),
+		QueryType:   row["query_type"].(string),
+		QueryPort:   port,
+		GamePort:    gamePort,
+		IdleMinutes: minutes,
+		ChannelID:   row["channel_id"].(string),
+	}
+}
+
+// getServerSettings returns the settings of the instances matching where.
+func getServerSettings(where map[string]interface{}) ([]serverSettings, error) {
+	data, err := queryDB("server_settings", where)
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]serverSettings, 0, len(data))
+	for _, d := range data {
+		settings = append(settings, newServerSettingsFromRow(d))
+	}
+	sort.Slice(settings, func(a, b int) bool {
+		return settings[a].InstanceID < settings[b].InstanceID
+	})
+	return settings, nil
+}
+
+// saveServerSettings creates or replaces the settings of an instance.
+func saveServerSettings(st serverSettings) error {
+	ctx := context.TODO()
+	return store.Tx(ctx, func(tx GuildStore) error {
+		where := map[string]interface{}{
+			"guild_id":    st.GuildID,
+			"region":      st.Region,
+			"instance_id": st.InstanceID,
+		}
+		values := map[string]interface{}{
+			"query_type":   st.QueryType,
+			"query_port":   st.QueryPort,
+			"game_port":    nullIfEmpty(st.GamePort),
+			"idle_minutes": st.IdleMinutes,
+			"channel_id":   nullIfEmpty(st.ChannelID),
+		}
+		current, err := tx.Query(ctx, "server_settings", where)
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			for k, v := range where {
+				values[k] = v
+			}
+			return tx.Insert(ctx, "server_settings", values)
+		}
+		_, err = tx.Update(ctx, "server_settings", where, values)
+		return err
+	})
+}
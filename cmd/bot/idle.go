@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// How often the idle scheduler checks the player count of every server.
+	idleCheckInterval = time.Minute
+	// How long before stopping an idle server the warning is posted.
+	idleWarningLead = 5 * time.Minute
+)
+
+// Bounds of the /idle set options.
+var (
+	minPort        = 1.0
+	maxPort        = 65535.0
+	minIdleMinutes = 0.0
+)
+
+// idleState tracks a running server that has no players.
+type idleState struct {
+	// since is when the server was first seen without players.
+	since time.Time
+	// warning is the posted shutdown warning, nil until there is one.
+	warning *discordgo.Message
+	// unanswered counts the game queries in a row the server didn't answer.
+	unanswered int
+}
+
+// idleStates holds the state of every idle server, keyed by idleKey. It only
+// lives in memory, so a restart gives every server a fresh idle period.
+var (
+	idleMu     sync.Mutex
+	idleStates = make(map[string]*idleState)
+)
+
+func idleKey(guildID string, region string, instanceID string) string {
+	return guildID + "|" + region + "|" + instanceID
+}
+
+// runIdleScheduler stops servers that have gone without players for longer
+// than their idle period, warning their channel first.
+func runIdleScheduler(s *discordgo.Session) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkIdleServers(s)
+	}
+}
+
+func checkIdleServers(s *discordgo.Session) {
+	settings, err := getServerSettings(nil)
+	if err != nil {
+		log.Println("Error reading server settings:", err)
+		return
+	}
+
+	checked := make(map[string]bool, len(settings))
+	for _, st := range settings {
+		if st.IdleMinutes <= 0 {
+			continue
+		}
+		checked[idleKey(st.GuildID, st.Region, st.InstanceID)] = true
+		if err := checkIdleServer(s, st); err != nil {
+			log.Printf("Error checking if %s in %s is idle: %v", st.InstanceID, st.Region, err)
+		}
+	}
+
+	// Forget servers whose settings were removed.
+	idleMu.Lock()
+	for key := range idleStates {
+		if !checked[key] {
+			delete(idleStates, key)
+		}
+	}
+	idleMu.Unlock()
+}
+
+func checkIdleServer(s *discordgo.Session, st serverSettings) error {
+	ctx := context.TODO()
+	key := idleKey(st.GuildID, st.Region, st.InstanceID)
+
+	provider, err := getGuildProvider(st.GuildID, st.Region)
+	if err != nil {
+		return err
+	}
+	instance, err := provider.Describe(ctx, st.InstanceID)
+	if err != nil {
+		return err
+	}
+	if instance.State != "running" {
+		resetIdle(s, key, fmt.Sprintf("`%s` in `%s` is `%s`, it is no longer idle.", st.InstanceID, st.Region, strings.ToUpper(instance.State)))
+		return nil
+	}
+
+	// A server whose game crashed or hangs can't have players, so a server
+	// that doesn't answer counts as idle too. The idle period gives a server
+	// that is still starting time to answer.
+	info, err := queryGameServer(ctx, st.QueryType, instance.PublicIP, st.QueryPort)
+	if err != nil {
+		log.Printf("Error querying the game on %s in %s: %v", st.InstanceID, st.Region, err)
+	}
+	if err == nil && info.Players > 0 {
+		resetIdle(s, key, fmt.Sprintf("Players are back on `%s` in `%s`, it will keep running.", st.InstanceID, st.Region))
+		return nil
+	}
+
+	idleMu.Lock()
+	state, ok := idleStates[key]
+	if !ok {
+		state = &idleState{since: time.Now()}
+		idleStates[key] = state
+	}
+	if err != nil {
+		state.unanswered++
+	} else {
+		state.unanswered = 0
+	}
+	// Copied under the lock, keep alive may reset them at any time.
+	since, warning, unanswered := state.since, state.warning, state.unanswered
+	idleFor := time.Since(since)
+	limit := time.Duration(st.IdleMinutes) * time.Minute
+	stop := idleFor >= limit
+	if stop {
+		delete(idleStates, key)
+	}
+	idleMu.Unlock()
+
+	switch {
+	case stop:
+		stopIdleServer(s, st, provider, warning, unanswered)
+	case warning == nil && idleFor >= limit-idleWarningLead:
+		warning := sendIdleWarning(s, st, since.Add(limit), unanswered)
+		idleMu.Lock()
+		// Keep alive may have been pressed in the meantime.
+		if idleStates[key] == state && state.since.Equal(since) {
+			state.warning = warning
+		}
+		idleMu.Unlock()
+	}
+	return nil
+}
+
+// resetIdle forgets that the server behind key was idle, updating its
+// warning with content if one was posted.
+func resetIdle(s *discordgo.Session, key string, content string) {
+	idleMu.Lock()
+	state, ok := idleStates[key]
+	delete(idleStates, key)
+	idleMu.Unlock()
+
+	if ok && state.warning != nil {
+		editIdleMessage(s, state.warning, content)
+	}
+}
+
+// sendIdleWarning posts that the server will be stopped at stopAt, because it
+// has no players or didn't answer the last unanswered queries.
+func sendIdleWarning(s *discordgo.Session, st serverSettings, stopAt time.Time, unanswered int) *discordgo.Message {
+	if st.ChannelID == "" {
+		return nil
+	}
+	reason := "has no players"
+	if unanswered > 0 {
+		reason = fmt.Sprintf("doesn't answer its `%s` query", st.QueryType)
+	}
+	msg, err := s.ChannelMessageSendComplex(st.ChannelID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("`%s` in `%s` %s and will be stopped <t:%d:R>.", st.InstanceID, st.Region, reason, stopAt.Unix()),
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Keep alive",
+						Style:    discordgo.PrimaryButton,
+						CustomID: "keep_alive:" + st.Region + ":" + st.InstanceID,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Println("Error sending idle warning:", err)
+		return nil
+	}
+	return msg
+}
+
+func stopIdleServer(s *discordgo.Session, st serverSettings, provider ServerProvider, warning *discordgo.Message, unanswered int) {
+	event := &auditEvent{
+		GuildID:    st.GuildID,
+		UserID:     s.State.User.ID,
+		Command:    "idle-shutdown",
+		Region:     st.Region,
+		InstanceID: st.InstanceID,
+		Outcome:    outcomeSuccess,
+	}
+	change, err := provider.Stop(context.TODO(), st.InstanceID, false)
+
+	var content string
+	if err != nil {
+		event.Outcome = outcomeFailure
+		event.Error = err.Error()
+		content = fmt.Sprintf("Could not stop idle server `%s` in `%s`...\n```%s```", st.InstanceID, st.Region, err)
+	} else {
+		content = fmt.Sprintf("Stopped `%s` in `%s` after %d minutes without players: %s", st.InstanceID, st.Region, st.IdleMinutes, change)
+		if unanswered > 0 {
+			content = fmt.Sprintf("Stopped `%s` in `%s` after %d minutes without players, its last %d `%s` queries went unanswered: %s", st.InstanceID, st.Region, st.IdleMinutes, unanswered, st.QueryType, change)
+		}
+	}
+	saveAudit(s, event)
+
+	switch {
+	case warning != nil:
+		editIdleMessage(s, warning, content)
+	case st.ChannelID != "":
+		if _, err := s.ChannelMessageSend(st.ChannelID, content); err != nil {
+			log.Println("Error sending idle shutdown:", err)
+		}
+	}
+}
+
+// editIdleMessage replaces an idle warning with content, removing its button.
+func editIdleMessage(s *discordgo.Session, msg *discordgo.Message, content string) {
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         msg.ID,
+		Channel:    msg.ChannelID,
+		Content:    &content,
+		Components: []discordgo.MessageComponent{},
+	})
+	if err != nil {
+		log.Println("Error updating idle warning:", err)
+	}
+}
+
+// keepAlive restarts the idle period of the server in the keep_alive button pressed.
+func keepAlive(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	args := getCustomIDArgs(i.MessageComponentData().CustomID)
+	if len(args) < 2 {
+		return
+	}
+	region, instanceID := args[0], args[1]
+	auditInstance(i, instanceID)
+
+	idleMu.Lock()
+	state, ok := idleStates[idleKey(i.GuildID, region, instanceID)]
+	if ok {
+		state.since = time.Now()
+		state.warning = nil
+	}
+	idleMu.Unlock()
+
+	// Nothing was reset, so the warning it came from is left as it is.
+	if !ok {
+		sendMessageEphemeral(s, i, fmt.Sprintf("There is no pending shutdown for `%s` in `%s`", instanceID, region))
+		return
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    fmt.Sprintf("<@%s> kept `%s` in `%s` alive. ValBot will warn again before stopping it.", i.Member.User.ID, instanceID, region),
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+}
+
+// idleSubcommands implements /idle set|remove|list.
+var idleSubcommands = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string){
+	"set": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		if _, ok := gameQueries[args["query"]]; !ok {
+			sendMessageEphemeral(s, i, fmt.Sprintf("Unknown game query `%s`, expected one of: %s", args["query"], strings.Join(getGameQueryNames(), ", ")))
+			return
+		}
+		provider, err := getProvider(args)
+		var instanceID string
+		if err == nil {
+			instanceID, err = resolveInstance(provider, args)
+		}
+		st := serverSettings{
+			GuildID:    args["guild_id"],
+			Region:     args["region"],
+			InstanceID: instanceID,
+			QueryType:  args["query"],
+			ChannelID:  args["channel"],
+		}
+		if st.ChannelID == "" {
+			st.ChannelID = i.ChannelID
+		}
+		if err == nil {
+			st.QueryPort, err = strconv.Atoi(args["port"])
+		}
+		if err == nil && args["game-port"] != "" {
+			st.GamePort, err = strconv.Atoi(args["game-port"])
+		}
+		if err == nil && args["minutes"] != "" {
+			st.IdleMinutes, err = strconv.Atoi(args["minutes"])
+		}
+		if err == nil {
+			auditInstance(i, instanceID)
+			err = saveServerSettings(st)
+		}
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Saved settings of `%s` in `%s`: %s", instanceID, st.Region, formatServerSettings(st)))
+	},
+	"remove": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		provider, err := getProvider(args)
+		var instanceID string
+		if err == nil {
+			instanceID, err = resolveInstance(provider, args)
+		}
+		var n int64
+		if err == nil {
+			auditInstance(i, instanceID)
+			n, err = deleteDB("server_settings", map[string]interface{}{
+				"guild_id":    args["guild_id"],
+				"region":      args["region"],
+				"instance_id": instanceID,
+			})
+		}
+		switch {
+		case err != nil:
+			sendError(s, i, err)
+		case n == 0:
+			sendMessageEphemeral(s, i, fmt.Sprintf("No settings for `%s` in `%s`", instanceID, args["region"]))
+		default:
+			sendMessageEphemeral(s, i, fmt.Sprintf("Removed settings of `%s` in `%s`", instanceID, args["region"]))
+		}
+	},
+	"list": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		settings, err := getServerSettings(map[string]interface{}{
+			"guild_id": args["guild_id"],
+			"region":   args["region"],
+		})
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		if len(settings) == 0 {
+			sendMessageEphemeral(s, i, fmt.Sprintf("No server settings in `%s`, add some with `/idle set`", args["region"]))
+			return
+		}
+		var lines []string
+		for _, st := range settings {
+			lines = append(lines, fmt.Sprintf("`%s`: %s", st.InstanceID, formatServerSettings(st)))
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Server settings in `%s`:\n%s", args["region"], strings.Join(lines, "\n")))
+	},
+}
+
+func formatServerSettings(st serverSettings) string {
+	idle := "never stopped when idle"
+	if st.IdleMinutes > 0 {
+		idle = fmt.Sprintf("stopped after %d minutes without players", st.IdleMinutes)
+		if st.ChannelID != "" {
+			idle += fmt.Sprintf(", warning in <#%s>", st.ChannelID)
+		}
+	}
+	ports := fmt.Sprintf("`%s` query on port `%d`", st.QueryType, st.QueryPort)
+	if st.GamePort != 0 {
+		ports += fmt.Sprintf(", game on port `%d`", st.GamePort)
+	}
+	return fmt.Sprintf("%s, %s", ports, idle)
+}
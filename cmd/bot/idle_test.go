@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// serveA2SPlayers runs an A2S server reporting players, returning its port.
+func serveA2SPlayers(t *testing.T, players byte) int {
+	t.Helper()
+	addr := serveA2S(t, func(req []byte) [][]byte {
+		if req[4] == a2sInfoRequest {
+			return [][]byte{a2sPacket(a2sInfoBody("Vikings", players, 10, 0))}
+		}
+		return [][]byte{a2sPacket(a2sPlayersBody())}
+	})
+	return portOf(t, addr)
+}
+
+// closedPort returns a local UDP port nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	return portOf(t, conn.LocalAddr().String())
+}
+
+func portOf(t *testing.T, addr string) int {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// useIdleServer initializes the test guild with a running server on this
+// host, and returns its settings with the query on port.
+func useIdleServer(t *testing.T, port int) (*memoryProvider, serverSettings) {
+	t.Helper()
+	useTestStore(t)
+	p := newMemoryProvider(Instance{ID: "i-game", State: "running", PublicIP: "127.0.0.1"})
+	useMemoryProvider(t, p)
+	t.Cleanup(func() {
+		idleMu.Lock()
+		idleStates = make(map[string]*idleState)
+		idleMu.Unlock()
+	})
+	return p, serverSettings{
+		GuildID:     testGuildID,
+		Region:      testRegion,
+		InstanceID:  "i-game",
+		QueryType:   "a2s",
+		QueryPort:   port,
+		IdleMinutes: 30,
+		ChannelID:   "idle",
+	}
+}
+
+// idleFor moves the start of the idle period of st d into the past.
+func idleFor(t *testing.T, st serverSettings, d time.Duration) {
+	t.Helper()
+	idleMu.Lock()
+	defer idleMu.Unlock()
+	state, ok := idleStates[idleKey(st.GuildID, st.Region, st.InstanceID)]
+	if !ok {
+		t.Fatal("server is not idle")
+	}
+	state.since = time.Now().Add(-d)
+}
+
+func TestCheckIdleServer(t *testing.T) {
+	p, st := useIdleServer(t, serveA2SPlayers(t, 0))
+	s, rt := newTestSession(t)
+
+	if err := checkIdleServer(s, st); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rt.messages()); n != 0 {
+		t.Errorf("sent %d messages for a server that just went idle", n)
+	}
+
+	idleFor(t, st, 26*time.Minute)
+	if err := checkIdleServer(s, st); err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastContent(); !strings.Contains(got, "has no players and will be stopped") {
+		t.Errorf("content = %q, want the idle warning", got)
+	}
+
+	idleFor(t, st, 31*time.Minute)
+	if err := checkIdleServer(s, st); err != nil {
+		t.Fatal(err)
+	}
+	if state := p.state(t, "i-game"); state != "stopped" {
+		t.Errorf("state = %s, want stopped", state)
+	}
+	if got := rt.lastContent(); !strings.Contains(got, "after 30 minutes without players") {
+		t.Errorf("content = %q, want the shutdown", got)
+	}
+}
+
+func TestCheckIdleServerWithPlayers(t *testing.T) {
+	p, st := useIdleServer(t, serveA2SPlayers(t, 3))
+	s, _ := newTestSession(t)
+	key := idleKey(st.GuildID, st.Region, st.InstanceID)
+	idleStates[key] = &idleState{since: time.Now().Add(-time.Hour)}
+
+	if err := checkIdleServer(s, st); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idleStates[key]; ok {
+		t.Error("server with players is still idle")
+	}
+	if state := p.state(t, "i-game"); state != "running" {
+		t.Errorf("state = %s, want running", state)
+	}
+}
+
+func TestCheckIdleServerUnanswered(t *testing.T) {
+	// A crashed game doesn't answer, the server must still be stopped.
+	p, st := useIdleServer(t, closedPort(t))
+	s, rt := newTestSession(t)
+
+	for n := 0; n < 2; n++ {
+		if err := checkIdleServer(s, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idleFor(t, st, 26*time.Minute)
+	if err := checkIdleServer(s, st); err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastContent(); !strings.Contains(got, "doesn't answer its `a2s` query") {
+		t.Errorf("content = %q, want the idle warning", got)
+	}
+
+	idleFor(t, st, 31*time.Minute)
+	if err := checkIdleServer(s, st); err != nil {
+		t.Fatal(err)
+	}
+	if state := p.state(t, "i-game"); state != "stopped" {
+		t.Errorf("state = %s, want stopped", state)
+	}
+	if got := rt.lastContent(); !strings.Contains(got, "its last 4 `a2s` queries went unanswered") {
+		t.Errorf("content = %q, want the shutdown", got)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	_, st := useIdleServer(t, closedPort(t))
+	s, rt := newTestSession(t)
+	customID := "keep_alive:" + st.Region + ":" + st.InstanceID
+
+	// The server may have been stopped since the warning was sent.
+	handleInteraction(s, componentInteraction(customID))
+	msg := rt.lastMessage()
+	if got, _ := msg["content"].(string); !strings.Contains(got, "no pending shutdown for `i-game`") {
+		t.Errorf("content = %q, want no pending shutdown", got)
+	}
+	if flags, _ := msg["flags"].(float64); int(flags)&int(discordgo.MessageFlagsEphemeral) == 0 {
+		t.Errorf("flags = %v, want an ephemeral reply", msg["flags"])
+	}
+
+	key := idleKey(st.GuildID, st.Region, st.InstanceID)
+	idleStates[key] = &idleState{since: time.Now().Add(-time.Hour)}
+	handleInteraction(s, componentInteraction(customID))
+	if got := rt.lastContent(); !strings.Contains(got, "kept `i-game` in `us-east-1` alive") {
+		t.Errorf("content = %q, want the server kept alive", got)
+	}
+	if since := idleStates[key].since; time.Since(since) > time.Minute {
+		t.Errorf("idle since %v, want the idle period restarted", since)
+	}
+}
+
+func TestIdleSetHasQueries(t *testing.T) {
+	if len(getGameQueryChoices()) == 0 {
+		t.Error("/idle set offers no game queries")
+	}
+}
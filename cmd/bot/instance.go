@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+// Instance is a single server as reported by a ServerProvider.
+type Instance struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	State            string            `json:"state"`
+	PublicIP         string            `json:"public_ip"`
+	PrivateIP        string            `json:"private_ip"`
+	InstanceType     string            `json:"instance_type"`
+	LaunchTime       time.Time         `json:"launch_time"`
+	AvailabilityZone string            `json:"availability_zone"`
+	Tags             map[string]string `json:"tags"`
+	Hibernation      bool              `json:"hibernation"`
+	// Game is what the game server on the instance reports, filled in by
+	// /status for instances with a query port. Providers leave it nil.
+	Game *ServerInfo `json:"game,omitempty"`
+}
+
+// Uptime is the time since the instance was launched, or zero when it is not running.
+func (i Instance) Uptime() time.Duration {
+	if i.State != "running" || i.LaunchTime.IsZero() {
+		return 0
+	}
+	return time.Since(i.LaunchTime).Truncate(time.Second)
+}
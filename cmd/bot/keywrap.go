@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// KeyWrapper encrypts the per-row data keys with a master key it holds, so
+// only wrapped data keys are ever stored.
+type KeyWrapper interface {
+	// Name identifies the wrapper. It prefixes every stored data key so the
+	// right wrapper can be found to unwrap it again.
+	Name() string
+	Wrap(ctx context.Context, dataKey []byte) (string, error)
+	Unwrap(ctx context.Context, wrapped string) ([]byte, error)
+}
+
+// keyWrapper wraps the data keys of newly saved credentials.
+var keyWrapper KeyWrapper
+
+// newKeyWrapper builds the wrapper described by the --kms flag:
+//   - "" uses the VBOT_AES_KEY keyring
+//   - local:<file> uses a keyring read from file
+//   - vault:<key> uses the HashiCorp Vault transit key, see VAULT_ADDR and VAULT_TOKEN
+//   - awskms:<key id> uses the AWS KMS key, with the bot's own AWS credentials
+func newKeyWrapper(kms string) (KeyWrapper, error) {
+	name, arg, _ := strings.Cut(kms, ":")
+	switch name {
+	case "":
+		return newKeyWrapperFor("local")
+	case "local":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		kr, err := parseKeyring(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", arg, err)
+		}
+		// The file can take over from VBOT_AES_KEY for older rows too.
+		if keys == nil {
+			keys = kr
+		}
+		return &localKeyWrapper{keys: kr}, nil
+	case "vault":
+		return newVaultKeyWrapper(arg)
+	case "awskms":
+		return newKMSKeyWrapper(arg)
+	}
+	return nil, fmt.Errorf("unknown key wrapper %q", name)
+}
+
+// newKeyWrapperFor builds a wrapper able to unwrap data keys wrapped by name,
+// for rows written before the wrapper was changed.
+func newKeyWrapperFor(name string) (KeyWrapper, error) {
+	switch name {
+	case "local":
+		if keys == nil {
+			return nil, errors.New("VBOT_AES_KEY is not set")
+		}
+		return &localKeyWrapper{keys: keys}, nil
+	case "vault":
+		return newVaultKeyWrapper("")
+	case "awskms":
+		return newKMSKeyWrapper("")
+	}
+	return nil, fmt.Errorf("unknown key wrapper %q", name)
+}
+
+// localKeyWrapper wraps data keys with a keyring held by the bot.
+type localKeyWrapper struct {
+	keys *keyring
+}
+
+func (w *localKeyWrapper) Name() string {
+	return "local"
+}
+
+func (w *localKeyWrapper) Wrap(ctx context.Context, dataKey []byte) (string, error) {
+	return w.keys.seal(dataKey)
+}
+
+func (w *localKeyWrapper) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	return w.keys.open(wrapped)
+}
+
+// vaultKeyWrapper wraps data keys with a HashiCorp Vault transit key.
+// Wrapped keys are stored as "<key>:<vault ciphertext>".
+type vaultKeyWrapper struct {
+	client *http.Client
+	addr   string
+	token  string
+	mount  string
+	key    string
+}
+
+func newVaultKeyWrapper(key string) (KeyWrapper, error) {
+	w := &vaultKeyWrapper{
+		client: &http.Client{Timeout: 10 * time.Second},
+		addr:   strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		token:  os.Getenv("VAULT_TOKEN"),
+		mount:  os.Getenv("VAULT_TRANSIT_MOUNT"),
+		key:    key,
+	}
+	if w.addr == "" || w.token == "" {
+		return nil, errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	if w.mount == "" {
+		w.mount = "transit"
+	}
+	return w, nil
+}
+
+func (w *vaultKeyWrapper) Name() string {
+	return "vault"
+}
+
+func (w *vaultKeyWrapper) Wrap(ctx context.Context, dataKey []byte) (string, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := w.post(ctx, "encrypt/"+w.key, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return w.key + ":" + resp.Data.Ciphertext, nil
+}
+
+func (w *vaultKeyWrapper) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	key, ciphertext, ok := strings.Cut(wrapped, ":")
+	if !ok {
+		return nil, errors.New("malformed vault data key")
+	}
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err := w.post(ctx, "decrypt/"+key, map[string]string{
+		"ciphertext": ciphertext,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+// post calls a transit endpoint and decodes its JSON response into out.
+func (w *vaultKeyWrapper) post(ctx context.Context, path string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/v1/%s/%s", w.addr, w.mount, path), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", w.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&vaultErr)
+		return fmt.Errorf("vault %s: %s %s", path, resp.Status, strings.Join(vaultErr.Errors, "; "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// kmsKeyWrapper wraps data keys with an AWS KMS key. Wrapped keys are the
+// base64 KMS ciphertext blob, which records the key that encrypted it.
+type kmsKeyWrapper struct {
+	client *kms.Client
+	keyID  string
+}
+
+func newKMSKeyWrapper(keyID string) (KeyWrapper, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	return &kmsKeyWrapper{client: kms.NewFromConfig(cfg), keyID: keyID}, nil
+}
+
+func (w *kmsKeyWrapper) Name() string {
+	return "awskms"
+}
+
+func (w *kmsKeyWrapper) Wrap(ctx context.Context, dataKey []byte) (string, error) {
+	resp, err := w.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(w.keyID),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(resp.CiphertextBlob), nil
+}
+
+func (w *kmsKeyWrapper) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: blob,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
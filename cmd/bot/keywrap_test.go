@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "1111111111111111111111111111111111111111111111111111111111111111"
+	testKey2 = "2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// useKeyWrapper makes w the wrapper of newly saved data keys for the test.
+func useKeyWrapper(t *testing.T, w KeyWrapper) {
+	t.Helper()
+	previous := keyWrapper
+	keyWrapper = w
+	t.Cleanup(func() { keyWrapper = previous })
+}
+
+func writeKeyringFile(t *testing.T, keyring string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keyring")
+	if err := os.WriteFile(path, []byte(keyring+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLocalKeyWrapper(t *testing.T) {
+	ctx := context.Background()
+	dataKey := bytes.Repeat([]byte{7}, 32)
+
+	old, err := newKeyWrapper("local:" + writeKeyringFile(t, "1:"+testKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := old.Wrap(ctx, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After a rotation, keys wrapped with the old entry still unwrap.
+	rotated, err := newKeyWrapper("local:" + writeKeyringFile(t, "1:"+testKey1+",2:"+testKey2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := rotated.Unwrap(ctx, wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("Unwrap() of the old key = %x, %v, want %x", got, err, dataKey)
+	}
+	rewrapped, err := rotated.Wrap(ctx, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Unwrap(ctx, rewrapped); err == nil {
+		t.Error("Unwrap() with a keyring missing the current key succeeded")
+	}
+
+	for _, kms := range []string{
+		"local:" + filepath.Join(t.TempDir(), "missing"),
+		"local:" + writeKeyringFile(t, "1:not hex"),
+		"unknown:key",
+	} {
+		if _, err := newKeyWrapper(kms); err == nil {
+			t.Errorf("newKeyWrapper(%q) succeeded", kms)
+		}
+	}
+}
+
+// serveVaultTransit fakes the encrypt and decrypt endpoints of a Vault transit
+// mount. Ciphertexts are the base64 plaintext prefixed with the key. Requests
+// for the key "denied" are refused.
+func serveVaultTransit(t *testing.T, mount string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		op, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/"+mount+"/"), "/")
+		if key == "denied" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var data map[string]string
+		switch op {
+		case "encrypt":
+			data = map[string]string{"ciphertext": "vault:" + key + ":" + req["plaintext"]}
+		case "decrypt":
+			plaintext := strings.TrimPrefix(req["ciphertext"], "vault:"+key+":")
+			if plaintext == req["ciphertext"] {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string][]string{"errors": {"invalid ciphertext"}})
+				return
+			}
+			data = map[string]string{"plaintext": plaintext}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("VAULT_ADDR", srv.URL+"/")
+	t.Setenv("VAULT_TOKEN", "token")
+	t.Setenv("VAULT_TRANSIT_MOUNT", mount)
+}
+
+func TestVaultKeyWrapper(t *testing.T) {
+	serveVaultTransit(t, "secrets")
+	ctx := context.Background()
+	dataKey := bytes.Repeat([]byte{7}, 32)
+
+	w, err := newKeyWrapper("vault:valbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := w.Wrap(ctx, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "valbot:vault:valbot:" + base64.StdEncoding.EncodeToString(dataKey); wrapped != want {
+		t.Errorf("Wrap() = %q, want %q", wrapped, want)
+	}
+	if got, err := w.Unwrap(ctx, wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("Unwrap() = %x, %v, want %x", got, err, dataKey)
+	}
+
+	denied, err := newKeyWrapper("vault:denied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = denied.Wrap(ctx, dataKey)
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Wrap() with a denied key error = %v, want the Vault error", err)
+	}
+	if _, err := w.Unwrap(ctx, "valbot:vault:other:AAAA"); err == nil || !strings.Contains(err.Error(), "invalid ciphertext") {
+		t.Errorf("Unwrap() of a foreign ciphertext error = %v, want the Vault error", err)
+	}
+	if _, err := w.Unwrap(ctx, "malformed"); err == nil {
+		t.Error("Unwrap() of a malformed key succeeded")
+	}
+
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := newKeyWrapper("vault:valbot"); err == nil {
+		t.Error("newKeyWrapper() without VAULT_TOKEN succeeded")
+	}
+}
+
+func TestUnwrapDataKeyFromOtherWrapper(t *testing.T) {
+	serveVaultTransit(t, "transit")
+	ctx := context.Background()
+	vault, err := newKeyWrapper("vault:valbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := newKeyWrapperFor("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A row written with each wrapper still opens after switching to the other.
+	for _, tt := range []struct{ from, to KeyWrapper }{{local, vault}, {vault, local}} {
+		useKeyWrapper(t, tt.from)
+		dataKey, wrapped, err := newDataKey(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(wrapped, tt.from.Name()+":") {
+			t.Errorf("newDataKey() = %q, want it prefixed with %s", wrapped, tt.from.Name())
+		}
+
+		useKeyWrapper(t, tt.to)
+		got, err := unwrapDataKey(ctx, wrapped)
+		if err != nil || !bytes.Equal(got, dataKey) {
+			t.Errorf("unwrapDataKey() of a %s key with %s = %x, %v, want %x", tt.from.Name(), tt.to.Name(), got, err, dataKey)
+		}
+	}
+
+	if _, err := unwrapDataKey(ctx, "unknown:key"); err == nil {
+		t.Error("unwrapDataKey() of an unknown wrapper succeeded")
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
@@ -15,13 +19,24 @@ var (
 	GuildID        = flag.String("guild", "", "Test guild ID. If not passed - bot registers commands globally")
 	BotToken       = flag.String("token", "", "Bot access token")
 	CryptKey       = flag.String("key", "", "AES Crypt Key")
+	KMS            = flag.String("kms", "", "Key wrapper for credential data keys: local:<keyfile>, vault:<transit key> or awskms:<key id>")
 	DatabaseURL    = flag.String("db", "", "Database Connection String")
 	RemoveCommands = flag.Bool("rmcmd", true, "Remove all commands after shutdowning or not")
+	RequireBound   = flag.Bool("require-bound", false, "Refuse credentials not bound to their guild, once `reencrypt` has run")
+	WaitTimeout    = flag.Duration("wait-timeout", 0, "How long to wait for an instance to reach its target state (max 14m)")
+)
+
+const (
+	defaultWaitTimeout = 5 * time.Minute
+	maxWaitTimeout     = 14 * time.Minute
 )
 
 var s *discordgo.Session
 
-func init() {
+// setup reads the bot parameters and opens the crypt key, the database and
+// the Discord session. It runs from main rather than init, so tests can load
+// the package without flags or a database.
+func setup() {
 	flag.Parse()
 	err := godotenv.Load()
 	if err != nil {
@@ -36,38 +51,107 @@ func init() {
 	if *CryptKey == "" {
 		*CryptKey = os.Getenv("VBOT_AES_KEY")
 	}
+	if *KMS == "" {
+		*KMS = os.Getenv("VBOT_KMS")
+	}
 	if *DatabaseURL == "" {
 		*DatabaseURL = os.Getenv("DATABASE_URL")
 	}
-	initCryptKey(*CryptKey)
-	initDB(*DatabaseURL)
-}
+	if *WaitTimeout == 0 {
+		*WaitTimeout = defaultWaitTimeout
+		if v := os.Getenv("VBOT_WAIT_TIMEOUT"); v != "" {
+			*WaitTimeout, err = time.ParseDuration(v)
+			if err != nil {
+				log.Fatalf("Invalid VBOT_WAIT_TIMEOUT: %v", err)
+			}
+		}
+	}
+	// Interaction tokens expire after 15 minutes, after which the
+	// deferred response can no longer be edited.
+	if *WaitTimeout > maxWaitTimeout {
+		log.Printf("Wait timeout %v is too long, using %v", *WaitTimeout, maxWaitTimeout)
+		*WaitTimeout = maxWaitTimeout
+	}
+	if !*RequireBound {
+		*RequireBound = os.Getenv("VBOT_REQUIRE_BOUND") == "true"
+	}
+	requireBound = *RequireBound
+	if err := initCryptKey(*CryptKey, *KMS); err != nil {
+		log.Fatalf("Cannot load the crypt key: %v", err)
+	}
+	if err := initDB(*DatabaseURL); err != nil {
+		log.Fatalf("Cannot open the database: %v", err)
+	}
 
-func init() {
-	var err error
 	s, err = discordgo.New("Bot " + *BotToken)
 	if err != nil {
 		log.Fatalf("Invalid bot parameters: %v", err)
 	}
+	s.AddHandler(handleInteraction)
 }
 
-func init() {
-	s.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		switch i.Type {
-		case discordgo.InteractionApplicationCommand:
-			if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-				h(s, i)
-			}
+// handleInteraction checks the capability an interaction needs and runs its handler.
+func handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	startAudit(i)
+	defer finishAudit(s, i)
+	defer recoverInteraction(s, i)
 
-		case discordgo.InteractionMessageComponent:
-			if h, ok := componentHandlers[i.MessageComponentData().CustomID]; ok {
-				h(s, i)
-			}
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		name := i.ApplicationCommandData().Name
+		if h, ok := commandHandlers[name]; ok && authorize(s, i, commandCapabilities[name]) {
+			h(s, i)
 		}
-	})
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		name := i.ApplicationCommandData().Name
+		if h, ok := autocompleteHandlers[name]; ok && authorize(s, i, commandCapabilities[name]) {
+			h(s, i)
+		}
+
+	case discordgo.InteractionMessageComponent:
+		name := getCustomIDName(i.MessageComponentData().CustomID)
+		if h, ok := componentHandlers[name]; ok && authorize(s, i, componentCapabilities[name]) {
+			h(s, i)
+		}
+
+	case discordgo.InteractionModalSubmit:
+		name := getCustomIDName(i.ModalSubmitData().CustomID)
+		if h, ok := modalHandlers[name]; ok && authorize(s, i, componentCapabilities[name]) {
+			h(s, i)
+		}
+	}
+}
+
+// recoverInteraction keeps a panicking handler from taking the bot down and
+// tells the member their command failed.
+func recoverInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("Recovered from panic handling interaction %s: %v\n%s", i.ID, r, debug.Stack())
+	if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		return
+	}
+	sendError(s, i, fmt.Errorf("%v", r))
 }
 
 func main() {
+	setup()
+	if flag.NArg() > 0 {
+		defer store.Close()
+		if err := runCLI(flag.Args()); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if _, err := store.MigrateUp(context.TODO()); err != nil {
+		log.Fatalf("Cannot migrate the database: %v", err)
+	}
+	go purgeExpiredCredsEvery(time.Hour)
+
 	s.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
 		log.Printf("Logged in as: %v#%v", s.State.User.Username, s.State.User.Discriminator)
 	})
@@ -75,6 +159,8 @@ func main() {
 	if err != nil {
 		log.Fatalf("Cannot open the session: %v", err)
 	}
+	go runIdleScheduler(s)
+	go runScheduler(s)
 
 	log.Println("Adding commands...")
 	registeredCommands := make([]*discordgo.ApplicationCommand, len(commands))
@@ -87,7 +173,7 @@ func main() {
 	}
 
 	defer s.Close()
-	defer db.Close()
+	defer store.Close()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// testCryptKey encrypts the credentials saved by tests.
+const testCryptKey = "2c8c1e4000000000000000000000000000000000000000000000000000000000"
+
+const (
+	testGuildID  = "guild"
+	testRegion   = "us-east-1"
+	testAdminID  = "admin"
+	testMemberID = "member"
+)
+
+func TestMain(m *testing.M) {
+	if err := initCryptKey(testCryptKey, ""); err != nil {
+		log.Fatalf("Cannot load the test crypt key: %v", err)
+	}
+	os.Exit(m.Run())
+}
+
+// useTestStore points the package store at a fresh, migrated SQLite database
+// for the duration of the test.
+func useTestStore(t *testing.T) GuildStore {
+	t.Helper()
+	st, err := openGuildStore("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	previous := store
+	store = st
+	t.Cleanup(func() {
+		store = previous
+		st.Close()
+	})
+	return st
+}
+
+// recordedRequest is a request a test session sent to Discord.
+type recordedRequest struct {
+	Method string
+	URL    string
+	Body   map[string]interface{}
+}
+
+// recordingTransport answers every Discord API request with an empty object
+// and records it, so tests can check what the handlers sent.
+type recordingTransport struct {
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := recordedRequest{Method: req.Method, URL: req.URL.String()}
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		// Multipart bodies with files are recorded without a body.
+		json.Unmarshal(data, &r.Body)
+	}
+	rt.mu.Lock()
+	rt.requests = append(rt.requests, r)
+	rt.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+// messages returns the message data of every response, edit and message sent.
+func (rt *recordingTransport) messages() []map[string]interface{} {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var messages []map[string]interface{}
+	for _, r := range rt.requests {
+		if r.Body == nil {
+			continue
+		}
+		if data, ok := r.Body["data"].(map[string]interface{}); ok {
+			messages = append(messages, data)
+			continue
+		}
+		messages = append(messages, r.Body)
+	}
+	return messages
+}
+
+// lastContent returns the content of the last message that had one.
+func (rt *recordingTransport) lastContent() string {
+	messages := rt.messages()
+	for n := len(messages) - 1; n >= 0; n-- {
+		if content, ok := messages[n]["content"].(string); ok && content != "" {
+			return content
+		}
+	}
+	return ""
+}
+
+// lastMessage returns the last message data sent.
+func (rt *recordingTransport) lastMessage() map[string]interface{} {
+	messages := rt.messages()
+	if len(messages) == 0 {
+		return nil
+	}
+	return messages[len(messages)-1]
+}
+
+func newTestSession(t *testing.T) (*discordgo.Session, *recordingTransport) {
+	t.Helper()
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &recordingTransport{}
+	s.Client = &http.Client{Transport: rt}
+	s.State.User = &discordgo.User{ID: "bot"}
+	return s, rt
+}
+
+var interactionCount int64
+
+func newTestInteraction(userID string, admin bool) *discordgo.Interaction {
+	member := &discordgo.Member{User: &discordgo.User{ID: userID}}
+	if admin {
+		member.Permissions = discordgo.PermissionAdministrator
+	}
+	return &discordgo.Interaction{
+		ID:        strconv.FormatInt(atomic.AddInt64(&interactionCount, 1), 10),
+		AppID:     "app",
+		GuildID:   testGuildID,
+		ChannelID: "channel",
+		Token:     "token",
+		Member:    member,
+	}
+}
+
+// commandInteraction is the slash command name run by an admin with options.
+func commandInteraction(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	i := newTestInteraction(testAdminID, true)
+	i.Type = discordgo.InteractionApplicationCommand
+	i.Data = discordgo.ApplicationCommandInteractionData{Name: name, Options: options}
+	return &discordgo.InteractionCreate{Interaction: i}
+}
+
+// componentInteraction is the component customID pressed by an admin.
+func componentInteraction(customID string, values ...string) *discordgo.InteractionCreate {
+	i := newTestInteraction(testAdminID, true)
+	i.Type = discordgo.InteractionMessageComponent
+	i.Data = discordgo.MessageComponentInteractionData{CustomID: customID, Values: values}
+	i.Message = &discordgo.Message{ID: "message", ChannelID: "channel"}
+	return &discordgo.InteractionCreate{Interaction: i}
+}
+
+func asMember(i *discordgo.InteractionCreate) *discordgo.InteractionCreate {
+	i.Member = &discordgo.Member{User: &discordgo.User{ID: testMemberID}}
+	return i
+}
+
+func subcommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:    name,
+		Type:    discordgo.ApplicationCommandOptionSubCommand,
+		Options: options,
+	}
+}
+
+func stringOption(name string, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionString,
+		Value: value,
+	}
+}
+
+func intOption(name string, value int) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionInteger,
+		Value: float64(value),
+	}
+}
+
+func boolOption(name string, value bool) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionBoolean,
+		Value: value,
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in a directory per dialect, named <version>_<name>.up.sql
+// and <version>_<name>.down.sql, and are applied in version order.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrationStatus is a migration with whether and when it was applied.
+type migrationStatus struct {
+	migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations reads the embedded migrations in dir, sorted by version.
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>", name)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", name, err)
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: title}
+			byVersion[version] = m
+		} else if m.Name != title {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up step", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(a, b int) bool {
+		return migrations[a].Version < migrations[b].Version
+	})
+	return migrations, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration lock,
+// after making sure the schema_migrations table exists.
+func (s *sqlStore) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := s.dialect.lockMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := conn.ExecContext(ctx, s.dialect.createMigrationsTable); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedMigrations returns when each applied migration version ran.
+func appliedMigrations(ctx context.Context, q querier) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration applies one migration step and records it in a single transaction.
+func (s *sqlStore) runMigration(ctx context.Context, conn *sql.Conn, m migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p := s.dialect.placeholder
+	if up {
+		if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+			return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s);", p(1), p(2), p(3)), m.Version, m.Name, time.Now().UTC())
+	} else {
+		if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %s;", p(1)), m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies every pending migration and returns how many ran.
+func (s *sqlStore) MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations(s.dialect.migrationsDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := s.runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown rolls back the latest steps applied migrations and returns how many ran.
+func (s *sqlStore) MigrateDown(ctx context.Context, steps int) (int, error) {
+	migrations, err := loadMigrations(s.dialect.migrationsDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = s.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down step", m.Version, m.Name)
+			}
+			if err := s.runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			log.Printf("Rolled back migration %d_%s", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrationStatus lists every known migration in version order. It only reads,
+// so it neither waits for a running migration nor creates schema_migrations.
+func (s *sqlStore) MigrationStatus(ctx context.Context) ([]migrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect.migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, s.dialect.hasMigrationsTable).Scan(&exists); err != nil {
+		return nil, err
+	}
+	// Without the table nothing was applied yet.
+	applied := make(map[int]time.Time)
+	if exists {
+		applied, err = appliedMigrations(ctx, s.db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]migrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		statuses = append(statuses, migrationStatus{migration: m, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+// Migrate Subcommands, e.g. `bot migrate status`.
+var migrateSubcommands = map[string]func(args []string) error{
+	"status": func(args []string) error {
+		statuses, err := store.MigrationStatus(context.TODO())
+		if err != nil {
+			return err
+		}
+		for _, m := range statuses {
+			status := "pending"
+			if m.Applied {
+				status = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, status)
+		}
+		return nil
+	},
+	"up": func(args []string) error {
+		count, err := store.MigrateUp(context.TODO())
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migrations", count)
+		return nil
+	},
+	"down": func(args []string) error {
+		steps := 1
+		if len(args) > 0 {
+			var err error
+			steps, err = strconv.Atoi(args[0])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations to roll back: %q", args[0])
+			}
+		}
+		count, err := store.MigrateDown(context.TODO(), steps)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rolled back %d migrations", count)
+		return nil
+	},
+}
+
+// migrateCmd runs `bot migrate status|up|down [n]`.
+func migrateCmd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected one of: status, up, down")
+	}
+	h, ok := migrateSubcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown migrate command %q, expected one of: status, up, down", args[0])
+	}
+	return h(args[1:])
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS guild_permissions;
+DROP TABLE IF EXISTS guild_servers;
+DROP TABLE IF EXISTS guild_aliases;
+DROP TABLE IF EXISTS guilds;
@@ -0,0 +1,42 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases created before
+-- migrations existed are adopted as-is.
+CREATE TABLE IF NOT EXISTS guilds (
+	id SERIAL PRIMARY KEY,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	aws_access_key_id TEXT,
+	aws_secret_access_key TEXT,
+	UNIQUE (guild_id, region)
+);
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT 'ec2';
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS auth_mode TEXT NOT NULL DEFAULT 'keys';
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS role_arn TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS data_key TEXT;
+
+CREATE TABLE IF NOT EXISTS guild_aliases (
+	id SERIAL PRIMARY KEY,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	alias TEXT NOT NULL,
+	instance_id TEXT NOT NULL,
+	UNIQUE (guild_id, region, alias)
+);
+
+CREATE TABLE IF NOT EXISTS guild_servers (
+	id SERIAL PRIMARY KEY,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (guild_id, region, kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS guild_permissions (
+	id SERIAL PRIMARY KEY,
+	guild_id TEXT NOT NULL,
+	subject_type TEXT NOT NULL,
+	subject_id TEXT NOT NULL,
+	capability TEXT NOT NULL,
+	UNIQUE (guild_id, subject_type, subject_id, capability)
+);
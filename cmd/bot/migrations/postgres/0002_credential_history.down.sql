@@ -0,0 +1,10 @@
+ALTER TABLE guilds DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE guilds DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_auth_mode;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_aws_access_key_id;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_aws_secret_access_key;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_role_arn;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_external_id;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_data_key;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_updated_by;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_updated_at;
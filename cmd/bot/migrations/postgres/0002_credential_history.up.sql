@@ -0,0 +1,12 @@
+-- Who last replaced the credentials of a guild region, and the values they
+-- replaced, kept so /init-rollback can restore them.
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS updated_by TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_auth_mode TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_aws_access_key_id TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_aws_secret_access_key TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_role_arn TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_external_id TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_data_key TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_updated_by TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_updated_at TIMESTAMPTZ;
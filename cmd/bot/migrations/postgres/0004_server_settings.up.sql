@@ -0,0 +1,13 @@
+-- How to query the game running on an instance, and when to stop it for
+-- being idle. idle_minutes of 0 never stops it.
+CREATE TABLE IF NOT EXISTS server_settings (
+	id SERIAL PRIMARY KEY,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	instance_id TEXT NOT NULL,
+	query_type TEXT NOT NULL,
+	query_port INTEGER NOT NULL,
+	idle_minutes INTEGER NOT NULL DEFAULT 0,
+	channel_id TEXT,
+	UNIQUE (guild_id, region, instance_id)
+);
@@ -0,0 +1,14 @@
+-- Cron rules starting or stopping an instance. next_run_at is a unix
+-- timestamp, claimed with a compare-and-set so a run fires at most once.
+CREATE TABLE IF NOT EXISTS schedules (
+	id SERIAL PRIMARY KEY,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	instance_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	cron TEXT NOT NULL,
+	timezone TEXT NOT NULL,
+	channel_id TEXT,
+	created_by TEXT,
+	next_run_at BIGINT NOT NULL
+);
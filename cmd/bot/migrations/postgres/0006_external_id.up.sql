@@ -0,0 +1,3 @@
+-- The external ID ValBot requires in the trust policy of every role a guild
+-- lets it assume. It is generated by ValBot, never chosen by the guild.
+ALTER TABLE guild_settings ADD COLUMN external_id TEXT;
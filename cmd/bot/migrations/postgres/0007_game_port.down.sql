@@ -0,0 +1 @@
+ALTER TABLE server_settings DROP COLUMN game_port;
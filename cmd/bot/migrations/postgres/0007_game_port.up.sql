@@ -0,0 +1,3 @@
+-- The port players connect to, which not every game shares with its query
+-- port, e.g. Valheim answers queries one port above. NULL when unknown.
+ALTER TABLE server_settings ADD COLUMN game_port INTEGER;
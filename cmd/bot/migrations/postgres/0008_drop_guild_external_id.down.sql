@@ -0,0 +1,2 @@
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS previous_external_id TEXT;
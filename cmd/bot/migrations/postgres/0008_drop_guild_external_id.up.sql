@@ -0,0 +1,4 @@
+-- The external ID of a role lives in guild_settings, generated by ValBot, so
+-- the copies a guild once entered itself are dropped.
+ALTER TABLE guilds DROP COLUMN IF EXISTS external_id;
+ALTER TABLE guilds DROP COLUMN IF EXISTS previous_external_id;
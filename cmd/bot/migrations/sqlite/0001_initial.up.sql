@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS guilds (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	aws_access_key_id TEXT,
+	aws_secret_access_key TEXT,
+	provider TEXT NOT NULL DEFAULT 'ec2',
+	auth_mode TEXT NOT NULL DEFAULT 'keys',
+	role_arn TEXT,
+	external_id TEXT,
+	data_key TEXT,
+	UNIQUE (guild_id, region)
+);
+
+CREATE TABLE IF NOT EXISTS guild_aliases (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	alias TEXT NOT NULL,
+	instance_id TEXT NOT NULL,
+	UNIQUE (guild_id, region, alias)
+);
+
+CREATE TABLE IF NOT EXISTS guild_servers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (guild_id, region, kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS guild_permissions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id TEXT NOT NULL,
+	subject_type TEXT NOT NULL,
+	subject_id TEXT NOT NULL,
+	capability TEXT NOT NULL,
+	UNIQUE (guild_id, subject_type, subject_id, capability)
+);
@@ -0,0 +1,10 @@
+ALTER TABLE guilds DROP COLUMN updated_by;
+ALTER TABLE guilds DROP COLUMN updated_at;
+ALTER TABLE guilds DROP COLUMN previous_auth_mode;
+ALTER TABLE guilds DROP COLUMN previous_aws_access_key_id;
+ALTER TABLE guilds DROP COLUMN previous_aws_secret_access_key;
+ALTER TABLE guilds DROP COLUMN previous_role_arn;
+ALTER TABLE guilds DROP COLUMN previous_external_id;
+ALTER TABLE guilds DROP COLUMN previous_data_key;
+ALTER TABLE guilds DROP COLUMN previous_updated_by;
+ALTER TABLE guilds DROP COLUMN previous_updated_at;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS guild_settings;
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	command TEXT NOT NULL,
+	region TEXT,
+	instance_id TEXT,
+	outcome TEXT NOT NULL,
+	error TEXT,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_events_guild_id ON audit_events (guild_id, id);
+
+CREATE TABLE IF NOT EXISTS guild_settings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id TEXT NOT NULL UNIQUE,
+	audit_channel_id TEXT
+);
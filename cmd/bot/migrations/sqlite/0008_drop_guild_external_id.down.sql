@@ -0,0 +1,2 @@
+ALTER TABLE guilds ADD COLUMN external_id TEXT;
+ALTER TABLE guilds ADD COLUMN previous_external_id TEXT;
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// minecraftQuery asks Minecraft Java Edition servers for their status with
+// the Server List Ping, the request the multiplayer menu of the game sends.
+// https://wiki.vg/Server_List_Ping
+type minecraftQuery struct{}
+
+const (
+	// Servers answer the status request whatever protocol version the
+	// handshake asks for, -1 is what clients send when they don't know it.
+	minecraftProtocolVersion = -1
+	minecraftStatusState     = 1
+	minecraftStatusPacket    = 0x00
+	// Packets longer than this can't be framed by the protocol.
+	minecraftMaxPacketSize = 1<<21 - 1
+)
+
+// minecraftStatus is the status JSON of a server.
+type minecraftStatus struct {
+	Version struct {
+		Name string `json:"name"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+		Sample []struct {
+			Name string `json:"name"`
+		} `json:"sample"`
+	} `json:"players"`
+	// Description is the MOTD, either a string or a chat component.
+	Description json.RawMessage `json:"description"`
+}
+
+func (minecraftQuery) Query(ctx context.Context, addr string) (ServerInfo, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, 0x00) // handshake packet
+	writeVarInt(&handshake, minecraftProtocolVersion)
+	writeVarInt(&handshake, int32(len(host)))
+	handshake.WriteString(host)
+	binary.Write(&handshake, binary.BigEndian, uint16(port))
+	writeVarInt(&handshake, minecraftStatusState)
+	if err := writeMinecraftPacket(conn, handshake.Bytes()); err != nil {
+		return ServerInfo{}, err
+	}
+	if err := writeMinecraftPacket(conn, []byte{minecraftStatusPacket}); err != nil {
+		return ServerInfo{}, err
+	}
+
+	packet, err := readMinecraftPacket(bufio.NewReader(conn))
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	r := bytes.NewReader(packet)
+	id, err := readVarInt(r)
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("minecraft: reading packet ID: %w", err)
+	}
+	if id != minecraftStatusPacket {
+		return ServerInfo{}, fmt.Errorf("minecraft: unexpected packet %#x", id)
+	}
+	length, err := readVarInt(r)
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("minecraft: reading status length: %w", err)
+	}
+	if length < 0 || int(length) > r.Len() {
+		return ServerInfo{}, errors.New("minecraft: status longer than its packet")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return ServerInfo{}, fmt.Errorf("minecraft: reading status: %w", err)
+	}
+
+	var status minecraftStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return ServerInfo{}, fmt.Errorf("minecraft: invalid status: %w", err)
+	}
+	info := ServerInfo{
+		Name:       parseMinecraftText(status.Description),
+		Version:    status.Version.Name,
+		Players:    status.Players.Online,
+		MaxPlayers: status.Players.Max,
+	}
+	for _, p := range status.Players.Sample {
+		info.PlayerNames = append(info.PlayerNames, p.Name)
+	}
+	return info, nil
+}
+
+// writeMinecraftPacket writes packet prefixed with its length.
+func writeMinecraftPacket(w io.Writer, packet []byte) error {
+	var b bytes.Buffer
+	writeVarInt(&b, int32(len(packet)))
+	b.Write(packet)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// readMinecraftPacket reads a length prefixed packet.
+func readMinecraftPacket(r *bufio.Reader) ([]byte, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if length <= 0 || length > minecraftMaxPacketSize {
+		return nil, fmt.Errorf("minecraft: invalid packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// writeVarInt writes v in 7 bit groups, least significant first, with the
+// high bit set on every byte but the last.
+func writeVarInt(b *bytes.Buffer, v int32) {
+	u := uint32(v)
+	for u >= 0x80 {
+		b.WriteByte(byte(u) | 0x80)
+		u >>= 7
+	}
+	b.WriteByte(byte(u))
+}
+
+func readVarInt(r io.ByteReader) (int32, error) {
+	var u uint32
+	for n := 0; n < 5; n++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		u |= uint32(b&0x7F) << (7 * n)
+		if b&0x80 == 0 {
+			return int32(u), nil
+		}
+	}
+	return 0, errors.New("minecraft: VarInt longer than 5 bytes")
+}
+
+// minecraftChat is a chat component, text styled and followed by extra
+// components.
+type minecraftChat struct {
+	Text  string            `json:"text"`
+	Extra []json.RawMessage `json:"extra"`
+}
+
+// parseMinecraftText returns the plain text of a string or chat component,
+// without its formatting codes, on a single line.
+func parseMinecraftText(raw json.RawMessage) string {
+	var b strings.Builder
+	appendMinecraftText(&b, raw)
+
+	// Formatting codes are § followed by the code.
+	var text []rune
+	skip := false
+	for _, r := range b.String() {
+		switch {
+		case skip:
+			skip = false
+		case r == '§':
+			skip = true
+		default:
+			text = append(text, r)
+		}
+	}
+	return strings.Join(strings.Fields(string(text)), " ")
+}
+
+func appendMinecraftText(b *strings.Builder, raw json.RawMessage) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		b.WriteString(s)
+		return
+	}
+	var chat minecraftChat
+	if err := json.Unmarshal(raw, &chat); err != nil {
+		return
+	}
+	b.WriteString(chat.Text)
+	for _, extra := range chat.Extra {
+		appendMinecraftText(b, extra)
+	}
+}
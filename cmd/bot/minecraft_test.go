@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveMinecraft answers each status request received on a local TCP socket
+// with reply, and returns the address to query.
+func serveMinecraft(t *testing.T, reply []byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// The handshake, then the status request.
+				for n := 0; n < 2; n++ {
+					if _, err := readMinecraftPacket(r); err != nil {
+						return
+					}
+				}
+				conn.Write(reply)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// minecraftPacket frames the fields of a packet with its length.
+func minecraftPacket(fields ...[]byte) []byte {
+	var b bytes.Buffer
+	writeMinecraftPacket(&b, bytes.Join(fields, nil))
+	return b.Bytes()
+}
+
+func varInt(v int32) []byte {
+	var b bytes.Buffer
+	writeVarInt(&b, v)
+	return b.Bytes()
+}
+
+// minecraftStatusResponse is the status response holding status.
+func minecraftStatusResponse(status string) []byte {
+	return minecraftPacket(varInt(minecraftStatusPacket), varInt(int32(len(status))), []byte(status))
+}
+
+func queryMinecraft(t *testing.T, addr string) (ServerInfo, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return minecraftQuery{}.Query(ctx, addr)
+}
+
+func TestVarInt(t *testing.T) {
+	tests := []struct {
+		v    int32
+		want []byte
+	}{
+		{v: 0, want: []byte{0x00}},
+		{v: 127, want: []byte{0x7F}},
+		{v: 128, want: []byte{0x80, 0x01}},
+		{v: 300, want: []byte{0xAC, 0x02}},
+		{v: minecraftMaxPacketSize, want: []byte{0xFF, 0xFF, 0x7F}},
+		{v: -1, want: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}},
+	}
+	for _, tt := range tests {
+		if got := varInt(tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("writeVarInt(%d) = %x, want %x", tt.v, got, tt.want)
+		}
+		if got, err := readVarInt(bytes.NewReader(tt.want)); err != nil || got != tt.v {
+			t.Errorf("readVarInt(%x) = %d, %v, want %d", tt.want, got, err, tt.v)
+		}
+	}
+
+	if _, err := readVarInt(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01})); err == nil || !strings.Contains(err.Error(), "longer than 5 bytes") {
+		t.Errorf("readVarInt() of 6 bytes error = %v, want it refused", err)
+	}
+}
+
+func TestMinecraftQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   ServerInfo
+	}{
+		{
+			name:   "string MOTD",
+			status: `{"version":{"name":"1.20.4","protocol":765},"players":{"max":20,"online":2,"sample":[{"name":"Steve","id":"1"},{"name":"Alex","id":"2"}]},"description":"§aA §lMinecraft\nServer"}`,
+			want:   ServerInfo{Name: "A Minecraft Server", Version: "1.20.4", Players: 2, MaxPlayers: 20, PlayerNames: []string{"Steve", "Alex"}},
+		},
+		{
+			name:   "chat MOTD",
+			status: `{"version":{"name":"Paper 1.20.4"},"players":{"max":10,"online":0},"description":{"text":"Hello ","color":"gold","extra":[{"text":"§cworld"},"!"]}}`,
+			want:   ServerInfo{Name: "Hello world!", Version: "Paper 1.20.4", MaxPlayers: 10},
+		},
+		{
+			// Longer than 127 bytes, both lengths take more than one byte.
+			name:   "multi-byte lengths",
+			status: `{"version":{"name":"1.20.4"},"players":{"max":20,"online":1},"description":"` + strings.Repeat("M", 300) + `"}`,
+			want:   ServerInfo{Name: strings.Repeat("M", 300), Version: "1.20.4", Players: 1, MaxPlayers: 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := queryMinecraft(t, serveMinecraft(t, minecraftStatusResponse(tt.status)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("Query() = %+v, want %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinecraftQueryInvalid(t *testing.T) {
+	status := minecraftStatusResponse(`{"version":{"name":"1.20.4"}}`)
+	tests := []struct {
+		name  string
+		reply []byte
+		want  string
+	}{
+		{name: "over-long length", reply: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, want: "longer than 5 bytes"},
+		{name: "empty packet", reply: varInt(0), want: "invalid packet length"},
+		{name: "truncated packet", reply: status[:len(status)-4], want: "EOF"},
+		{name: "truncated packet ID", reply: minecraftPacket([]byte{0x80}), want: "reading packet ID"},
+		{name: "unexpected packet", reply: minecraftPacket(varInt(0x01), varInt(2), []byte("{}")), want: "unexpected packet 0x1"},
+		{name: "truncated status length", reply: minecraftPacket(varInt(minecraftStatusPacket), []byte{0x80}), want: "reading status length"},
+		{name: "status longer than its packet", reply: minecraftPacket(varInt(minecraftStatusPacket), varInt(10), []byte("{}")), want: "status longer than its packet"},
+		{name: "invalid JSON", reply: minecraftStatusResponse(`{"version":`), want: "invalid status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := queryMinecraft(t, serveMinecraft(t, tt.reply))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Query() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
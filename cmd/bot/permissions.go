@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Capabilities, each one includes the ones before it.
+const (
+	capabilityView  = "view"
+	capabilityPower = "power"
+	capabilityAdmin = "admin"
+)
+
+var capabilityList = [...]string{capabilityView, capabilityPower, capabilityAdmin}
+
+// capabilityOpen marks commands and components everyone may use. It can't be
+// granted, it only has to be listed so nothing is left open by accident.
+const capabilityOpen = "open"
+
+func capabilityLevel(capability string) int {
+	for n, c := range capabilityList {
+		if c == capability {
+			return n + 1
+		}
+	}
+	return 0
+}
+
+func getCapabilityChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+
+	for _, c := range capabilityList {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  c,
+			Value: c,
+		})
+	}
+	return choices
+}
+
+// commandCapabilities is the capability needed to run each command, also used
+// for its autocomplete. Commands not listed can't be used by anyone.
+var commandCapabilities = map[string]string{
+	"help":          capabilityOpen,
+	"init":          capabilityAdmin,
+	"init-delete":   capabilityAdmin,
+	"init-rollback": capabilityAdmin,
+	"audit":         capabilityAdmin,
+	"idle":          capabilityAdmin,
+	"schedule":      capabilityAdmin,
+	"status":        capabilityView,
+	"start":         capabilityPower,
+	"stop":          capabilityPower,
+	"reboot":        capabilityPower,
+	"hibernate":     capabilityPower,
+	"alias":         capabilityAdmin,
+	"servers":       capabilityAdmin,
+	"permissions":   capabilityAdmin,
+}
+
+// componentCapabilities is the capability needed to use each message component
+// or submit each modal, keyed by custom ID name. Components not listed can't
+// be used by anyone.
+var componentCapabilities = map[string]string{
+	"refresh_status":   capabilityView,
+	"status_page":      capabilityView,
+	"srv_select":       capabilityView,
+	"init_credentials": capabilityAdmin,
+	"init_role":        capabilityAdmin,
+	"init_replace":     capabilityAdmin,
+	"init_role_form":   capabilityAdmin,
+	"init_cancel":      capabilityAdmin,
+	"keep_alive":       capabilityPower,
+	"srv_start":        capabilityPower,
+	"srv_stop":         capabilityPower,
+	"srv_reboot":       capabilityPower,
+	"srv_connect":      capabilityView,
+}
+
+// Subject types a capability can be granted to.
+const (
+	subjectRole = "role"
+	subjectUser = "user"
+)
+
+// hasCapability reports whether the member behind the interaction holds capability.
+// Members with the Discord Administrator permission hold every capability.
+// Nobody holds an unknown capability, including the empty one.
+func hasCapability(i *discordgo.InteractionCreate, capability string) (bool, error) {
+	if capability == capabilityOpen {
+		return true, nil
+	}
+	if i.Member == nil || capabilityLevel(capability) == 0 {
+		return false, nil
+	}
+	if i.Member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true, nil
+	}
+
+	// The @everyone role shares the guild ID and is not listed in the member roles.
+	subjects := map[string]bool{
+		subjectUser + "/" + i.Member.User.ID: true,
+		subjectRole + "/" + i.GuildID:        true,
+	}
+	for _, role := range i.Member.Roles {
+		subjects[subjectRole+"/"+role] = true
+	}
+
+	grants, err := queryDB("guild_permissions", map[string]interface{}{
+		"guild_id": i.GuildID,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, g := range grants {
+		if !subjects[g["subject_type"].(string)+"/"+g["subject_id"].(string)] {
+			continue
+		}
+		if capabilityLevel(g["capability"].(string)) >= capabilityLevel(capability) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorize checks capability and tells the member when they are missing it.
+func authorize(s *discordgo.Session, i *discordgo.InteractionCreate, capability string) bool {
+	ok, err := hasCapability(i, capability)
+	if ok {
+		return true
+	}
+	if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{},
+		})
+		return false
+	}
+	if err != nil {
+		sendError(s, i, err)
+		return false
+	}
+	auditDenied(i)
+	if capabilityLevel(capability) == 0 {
+		sendMessageEphemeral(s, i, "Nobody can do that, ValBot has no permission configured for it.")
+		return false
+	}
+	sendMessageEphemeral(s, i, fmt.Sprintf("You need the `%s` permission to do that. Ask an admin to grant it with `/permissions grant`.", capability))
+	return false
+}
+
+func formatSubject(subjectType string, subjectID string) string {
+	if subjectType == subjectRole {
+		return fmt.Sprintf("<@&%s>", subjectID)
+	}
+	return fmt.Sprintf("<@%s>", subjectID)
+}
+
+// getPermissionSubject returns the role or user picked in /permissions grant or revoke.
+func getPermissionSubject(args map[string]string) (string, string, error) {
+	switch {
+	case args["role"] != "" && args["user"] != "":
+		return "", "", fmt.Errorf("specify either a role or a user, not both")
+	case args["role"] != "":
+		return subjectRole, args["role"], nil
+	case args["user"] != "":
+		return subjectUser, args["user"], nil
+	}
+	return "", "", fmt.Errorf("specify a role or a user")
+}
+
+// permissionsSubcommands implements /permissions grant|revoke|list.
+var permissionsSubcommands = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string){
+	"grant": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		subjectType, subjectID, err := getPermissionSubject(args)
+		if err == nil {
+			err = insertDB("guild_permissions", map[string]interface{}{
+				"guild_id":     args["guild_id"],
+				"subject_type": subjectType,
+				"subject_id":   subjectID,
+				"capability":   args["capability"],
+			})
+		}
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Granted `%s` to %s", args["capability"], formatSubject(subjectType, subjectID)))
+	},
+	"revoke": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		subjectType, subjectID, err := getPermissionSubject(args)
+		var n int64
+		if err == nil {
+			n, err = deleteDB("guild_permissions", map[string]interface{}{
+				"guild_id":     args["guild_id"],
+				"subject_type": subjectType,
+				"subject_id":   subjectID,
+				"capability":   args["capability"],
+			})
+		}
+		switch {
+		case err != nil:
+			sendError(s, i, err)
+		case n == 0:
+			sendMessageEphemeral(s, i, fmt.Sprintf("%s was not granted `%s`", formatSubject(subjectType, subjectID), args["capability"]))
+		default:
+			sendMessageEphemeral(s, i, fmt.Sprintf("Revoked `%s` from %s", args["capability"], formatSubject(subjectType, subjectID)))
+		}
+	},
+	"list": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		grants, err := queryDB("guild_permissions", map[string]interface{}{
+			"guild_id": args["guild_id"],
+		})
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		if len(grants) == 0 {
+			sendMessageEphemeral(s, i, "No permissions granted, only Discord administrators can use ValBot. Grant some with `/permissions grant`.")
+			return
+		}
+		var lines []string
+		for _, g := range grants {
+			lines = append(lines, fmt.Sprintf("%s: `%s`", formatSubject(g["subject_type"].(string), g["subject_id"].(string)), g["capability"]))
+		}
+		sort.Strings(lines)
+		sendMessageEphemeral(s, i, fmt.Sprintf("Permissions (Discord administrators can do everything):\n%s", strings.Join(lines, "\n")))
+	},
+}
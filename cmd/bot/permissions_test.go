@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestEveryHandlerHasCapability(t *testing.T) {
+	listed := func(capability string) bool {
+		return capability == capabilityOpen || capabilityLevel(capability) > 0
+	}
+	for name := range commandHandlers {
+		if !listed(commandCapabilities[name]) {
+			t.Errorf("command %s has no capability", name)
+		}
+	}
+	for name := range componentHandlers {
+		if !listed(componentCapabilities[name]) {
+			t.Errorf("component %s has no capability", name)
+		}
+	}
+	for name := range modalHandlers {
+		if !listed(componentCapabilities[name]) {
+			t.Errorf("modal %s has no capability", name)
+		}
+	}
+}
+
+func TestHasCapability(t *testing.T) {
+	useTestStore(t)
+	tests := []struct {
+		name       string
+		capability string
+		admin      bool
+		want       bool
+	}{
+		{name: "open to members", capability: capabilityOpen, want: true},
+		{name: "view denied", capability: capabilityView, want: false},
+		{name: "admin holds admin", capability: capabilityAdmin, admin: true, want: true},
+		{name: "unlisted denied", capability: "", want: false},
+		{name: "unlisted denied to admins", capability: "", admin: true, want: false},
+		{name: "unknown denied to admins", capability: "superuser", admin: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := commandInteraction("help")
+			if !tt.admin {
+				i = asMember(i)
+			}
+			if got, err := hasCapability(i, tt.capability); got != tt.want || err != nil {
+				t.Errorf("hasCapability(%q) = %v, %v, want %v", tt.capability, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnlistedCommandDenied(t *testing.T) {
+	useTestStore(t)
+	s, rt := newTestSession(t)
+	var ran bool
+	commandHandlers["unlisted"] = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		ran = true
+	}
+	t.Cleanup(func() { delete(commandHandlers, "unlisted") })
+
+	handleInteraction(s, commandInteraction("unlisted"))
+	if ran {
+		t.Error("unlisted command ran")
+	}
+	if got := rt.lastContent(); !strings.Contains(got, "Nobody can do that") {
+		t.Errorf("content = %q, want it denied", got)
+	}
+
+	handleInteraction(s, asMember(commandInteraction("help")))
+	if got := rt.lastContent(); !strings.Contains(got, "/init") {
+		t.Errorf("help content = %q, want help for members", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ServerProvider is the backend the command handlers use to list and control
+// instances, so they never have to talk to a cloud SDK directly.
+type ServerProvider interface {
+	List(ctx context.Context) ([]Instance, error)
+	Describe(ctx context.Context, id string) (Instance, error)
+	Start(ctx context.Context, id string) (StateChange, error)
+	// Stop shuts the instance down. Force skips the graceful shutdown for
+	// instances that are stuck.
+	Stop(ctx context.Context, id string, force bool) (StateChange, error)
+	// Hibernate stops the instance, saving its memory to disk. It fails for
+	// instances that were not launched with hibernation enabled.
+	Hibernate(ctx context.Context, id string) (StateChange, error)
+	Reboot(ctx context.Context, id string) (StateChange, error)
+}
+
+// StateChange is the state transition reported by a power action.
+type StateChange struct {
+	ID       string
+	Previous string
+	Current  string
+}
+
+func (c StateChange) String() string {
+	if c.Previous == c.Current {
+		return fmt.Sprintf("`%s`", strings.ToUpper(c.Current))
+	}
+	return fmt.Sprintf("`%s` → `%s`", strings.ToUpper(c.Previous), strings.ToUpper(c.Current))
+}
+
+// providers maps the provider name stored with a guild region to its constructor.
+// Constructors receive the decrypted guild row merged with the command options.
+var providers = map[string]func(args map[string]string) (ServerProvider, error){
+	"ec2": newEC2Provider,
+}
+
+// getProvider resolves the provider configured for the guild region in args,
+// restricted to the servers the guild registered with /servers.
+func getProvider(args map[string]string) (ServerProvider, error) {
+	provider, err := getUnrestrictedProvider(args)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := getServerRules(args["guild_id"], args["region"])
+	if err != nil {
+		return nil, err
+	}
+	return newAllowlistProvider(provider, args["region"], rules), nil
+}
+
+// getGuildProvider resolves the provider of a guild region outside of an
+// interaction, for the background schedulers.
+func getGuildProvider(guildID string, region string) (ServerProvider, error) {
+	args := map[string]interface{}{"guild_id": guildID, "region": region}
+	data, err := getCredsFromDB(args)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range data {
+		for k, v := range d {
+			args[k] = v
+		}
+	}
+	return getProvider(convertMapValuesToString(args))
+}
+
+// getUnrestrictedProvider resolves the provider configured for the guild region
+// in args without applying the allowlist. Only /servers should need this.
+func getUnrestrictedProvider(args map[string]string) (ServerProvider, error) {
+	name, ok := args["provider"]
+	if !ok {
+		return nil, fmt.Errorf("ValBot is not initialized for region `%s`, run `/init` first", args["region"])
+	}
+	newProvider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider `%s`", name)
+	}
+	return newProvider(args)
+}
+
+// How often waitForState polls the provider.
+const waitPollInterval = 5 * time.Second
+
+var errWaitTimeout = errors.New("timed out waiting for instance state")
+
+// waitForState polls the provider until the instance reaches state or timeout
+// elapses. The last seen instance is returned in both cases.
+func waitForState(ctx context.Context, p ServerProvider, id string, state string, timeout time.Duration) (Instance, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	var instance Instance
+	for {
+		select {
+		case <-ctx.Done():
+			return instance, errWaitTimeout
+		case <-ticker.C:
+		}
+
+		current, err := p.Describe(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return instance, errWaitTimeout
+			}
+			return instance, err
+		}
+		instance = current
+		if instance.State == state {
+			return instance, nil
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// memoryProvider is a ServerProvider keeping its instances in memory. Power
+// actions settle at once, so handlers never wait on it.
+type memoryProvider struct {
+	mu        sync.Mutex
+	instances map[string]Instance
+}
+
+func newMemoryProvider(instances ...Instance) *memoryProvider {
+	p := &memoryProvider{instances: make(map[string]Instance, len(instances))}
+	for _, instance := range instances {
+		p.instances[instance.ID] = instance
+	}
+	return p
+}
+
+func (p *memoryProvider) List(ctx context.Context) ([]Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	instances := make([]Instance, 0, len(p.instances))
+	for _, instance := range p.instances {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(a, b int) bool {
+		return instances[a].ID < instances[b].ID
+	})
+	return instances, nil
+}
+
+func (p *memoryProvider) Describe(ctx context.Context, id string) (Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	instance, ok := p.instances[id]
+	if !ok {
+		return Instance{}, fmt.Errorf("instance `%s` not found", id)
+	}
+	return instance, nil
+}
+
+// transition moves the instance from state from to state to.
+func (p *memoryProvider) transition(id string, from string, to string) (StateChange, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	instance, ok := p.instances[id]
+	if !ok {
+		return StateChange{}, fmt.Errorf("instance `%s` not found", id)
+	}
+	if instance.State != from {
+		return StateChange{}, fmt.Errorf("instance `%s` is %s, not %s", id, instance.State, from)
+	}
+	instance.State = to
+	p.instances[id] = instance
+	return StateChange{ID: id, Previous: from, Current: to}, nil
+}
+
+func (p *memoryProvider) Start(ctx context.Context, id string) (StateChange, error) {
+	return p.transition(id, "stopped", "running")
+}
+
+func (p *memoryProvider) Stop(ctx context.Context, id string, force bool) (StateChange, error) {
+	return p.transition(id, "running", "stopped")
+}
+
+func (p *memoryProvider) Hibernate(ctx context.Context, id string) (StateChange, error) {
+	instance, err := p.Describe(ctx, id)
+	if err != nil {
+		return StateChange{}, err
+	}
+	if !instance.Hibernation {
+		return StateChange{}, fmt.Errorf("instance `%s` was not launched with hibernation enabled", id)
+	}
+	return p.transition(id, "running", "stopped")
+}
+
+func (p *memoryProvider) Reboot(ctx context.Context, id string) (StateChange, error) {
+	return p.transition(id, "running", "running")
+}
+
+func (p *memoryProvider) state(t *testing.T, id string) string {
+	t.Helper()
+	instance, err := p.Describe(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance.State
+}
+
+// useMemoryProvider initializes the test guild region with p, managing every
+// instance p has at the start of the test.
+func useMemoryProvider(t *testing.T, p *memoryProvider) {
+	t.Helper()
+	providers["memory"] = func(args map[string]string) (ServerProvider, error) {
+		return p, nil
+	}
+	t.Cleanup(func() {
+		delete(providers, "memory")
+		autocompleteCache.invalidate(testGuildID, testRegion)
+	})
+
+	err := insertDB("guilds", map[string]interface{}{
+		"guild_id": testGuildID,
+		"region":   testRegion,
+		"provider": "memory",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range p.instances {
+		err := insertDB("guild_servers", map[string]interface{}{
+			"guild_id": testGuildID,
+			"region":   testRegion,
+			"kind":     serverRuleID,
+			"value":    id,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAllowlistProvider(t *testing.T) {
+	p := newMemoryProvider(
+		Instance{ID: "i-1", State: "running", Tags: map[string]string{"game": "valheim"}},
+		Instance{ID: "i-2", State: "stopped", Tags: map[string]string{"game": "minecraft"}},
+		Instance{ID: "i-3", State: "stopped"},
+	)
+	allowed := newAllowlistProvider(p, testRegion, []serverRule{
+		{Kind: serverRuleTag, Value: "game=valheim"},
+		{Kind: serverRuleID, Value: "i-2"},
+	})
+	ctx := context.Background()
+
+	instances, err := allowed.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || instances[0].ID != "i-1" || instances[1].ID != "i-2" {
+		t.Errorf("List() = %v, want i-1 and i-2", instances)
+	}
+	if _, err := allowed.Start(ctx, "i-3"); err == nil {
+		t.Error("Start() of an unmanaged instance succeeded")
+	}
+	if p.state(t, "i-3") != "stopped" {
+		t.Error("Start() of an unmanaged instance reached the provider")
+	}
+	if _, err := allowed.Start(ctx, "i-2"); err != nil {
+		t.Errorf("Start() of a managed instance: %v", err)
+	}
+
+	none := newAllowlistProvider(p, testRegion, nil)
+	if _, err := none.Describe(ctx, "i-1"); err == nil {
+		t.Error("Describe() without rules succeeded")
+	}
+}
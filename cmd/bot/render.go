@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ecoshub/stable"
+)
+
+// Discord rejects message content longer than this.
+const maxMessageLength = 2000
+
+// statusView is a rendered /status response.
+type statusView struct {
+	Content string
+	Embeds  []*discordgo.MessageEmbed
+	Files   []*discordgo.File
+	// Page is the page of instances shown, out of Pages. Formats that don't
+	// paginate leave both zero.
+	Page  int
+	Pages int
+	// Shown are the instances on the page, which can be picked for the action
+	// buttons. Tables and JSON list every instance in one message, more than
+	// a select menu holds, so they leave it empty.
+	Shown []Instance
+}
+
+// statusRenderers maps the /status format option to its renderer.
+var statusRenderers = map[string]func(region string, instances []Instance, page int) statusView{
+	"table": renderTable,
+	"embed": renderEmbed,
+	"json":  renderJSON,
+}
+
+const defaultStatusFormat = "embed"
+
+var statusFormatList = [...]string{"table", "embed", "json"}
+
+func getStatusFormatChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+
+	for _, f := range statusFormatList {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  f,
+			Value: f,
+		})
+	}
+	return choices
+}
+
+func renderStatus(format string, region string, instances []Instance, page int) statusView {
+	render, ok := statusRenderers[format]
+	if !ok {
+		render = statusRenderers[defaultStatusFormat]
+	}
+	return render(region, instances, page)
+}
+
+// tableColumns are tried in order until the table fits in a single message.
+var tableColumns = [][]string{
+	{"NAME", "ID", "STATUS", "IP", "TYPE", "UPTIME"},
+	{"NAME", "ID", "STATUS", "IP"},
+	{"NAME", "STATUS", "IP"},
+}
+
+// gameTableColumns are tried before tableColumns when a game server answered.
+var gameTableColumns = [][]string{
+	{"NAME", "ID", "STATUS", "IP", "TYPE", "UPTIME", "SERVER", "MAP", "PLAYERS"},
+	{"NAME", "ID", "STATUS", "IP", "SERVER", "MAP", "PLAYERS"},
+	{"NAME", "STATUS", "IP", "MAP", "PLAYERS"},
+	{"NAME", "STATUS", "PLAYERS"},
+}
+
+func hasGame(instances []Instance) bool {
+	for _, instance := range instances {
+		if instance.Game != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func renderTable(region string, instances []Instance, _ int) statusView {
+	candidates := tableColumns
+	if hasGame(instances) {
+		candidates = gameTableColumns
+	}
+
+	var content string
+	for _, columns := range candidates {
+		table := stable.Basic(fmt.Sprintf("Status - %s", region), columns...)
+		for _, instance := range instances {
+			row := make([]interface{}, len(columns))
+			for c, column := range columns {
+				row[c] = instanceColumn(instance, column)
+			}
+			table.Row(row...)
+		}
+
+		content = fmt.Sprintf("```\n%s```", table.String())
+		if len(content) <= maxMessageLength {
+			return statusView{Content: content}
+		}
+	}
+
+	// Even the narrowest table is too long, cut it at a line boundary.
+	cut := strings.LastIndex(content[:maxMessageLength-len("\n...```")], "\n")
+	return statusView{Content: content[:cut] + "\n...```"}
+}
+
+func instanceColumn(instance Instance, column string) string {
+	switch column {
+	case "NAME":
+		return instance.Name
+	case "ID":
+		return instance.ID
+	case "STATUS":
+		return strings.ToUpper(instance.State)
+	case "IP":
+		return instance.PublicIP
+	case "TYPE":
+		return instance.InstanceType
+	case "UPTIME":
+		return formatUptime(instance.Uptime())
+	}
+	if instance.Game == nil {
+		return ""
+	}
+	switch column {
+	case "SERVER":
+		return instance.Game.Name
+	case "MAP":
+		return instance.Game.Map
+	case "PLAYERS":
+		return formatPlayers(*instance.Game)
+	}
+	return ""
+}
+
+func formatPlayers(info ServerInfo) string {
+	return fmt.Sprintf("%d/%d", info.Players, info.MaxPlayers)
+}
+
+// Discord rejects embed field values longer than this.
+const maxEmbedFieldLength = 1024
+
+// How many instances an embed page shows, well within Discord's limits of 25
+// fields and 6000 characters per embed, and of 25 options in the select menu
+// of the instances.
+const embedPageSize = 9
+
+// How many player names an instance field lists.
+const maxListedPlayers = 10
+
+// Colors of the status embed, by the overall state of the instances.
+const (
+	colorRunning  = 0x2ECC71
+	colorChanging = 0xF1C40F
+	colorStopped  = 0xE74C3C
+	colorUnknown  = 0x95A5A6
+)
+
+// stateEmojis show the state of an instance at a glance.
+var stateEmojis = map[string]string{
+	"running":       "🟢",
+	"pending":       "🟡",
+	"stopping":      "🟡",
+	"shutting-down": "🟠",
+	"stopped":       "🔴",
+	"terminated":    "⚫",
+}
+
+func stateEmoji(state string) string {
+	if emoji, ok := stateEmojis[state]; ok {
+		return emoji
+	}
+	return "⚪"
+}
+
+// statusColor is green when every instance is running, red when none is and
+// yellow in between.
+func statusColor(instances []Instance) int {
+	if len(instances) == 0 {
+		return colorUnknown
+	}
+	var running, stopped int
+	for _, instance := range instances {
+		switch instance.State {
+		case "running":
+			running++
+		case "stopped", "terminated":
+			stopped++
+		}
+	}
+	switch {
+	case running == len(instances):
+		return colorRunning
+	case stopped == len(instances):
+		return colorStopped
+	default:
+		return colorChanging
+	}
+}
+
+func renderEmbed(region string, instances []Instance, page int) statusView {
+	pages := (len(instances) + embedPageSize - 1) / embedPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	start := page * embedPageSize
+	end := start + embedPageSize
+	if end > len(instances) {
+		end = len(instances)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("Status - %s", region),
+		Color: statusColor(instances),
+	}
+	for _, instance := range instances[start:end] {
+		embed.Fields = append(embed.Fields, instanceField(instance))
+	}
+	if len(instances) == 0 {
+		embed.Description = "No instances found."
+	}
+	if pages > 1 {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d of %d, %d instances", page+1, pages, len(instances)),
+		}
+	}
+	return statusView{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Page:   page,
+		Pages:  pages,
+		Shown:  instances[start:end],
+	}
+}
+
+func instanceField(instance Instance) *discordgo.MessageEmbedField {
+	name := instance.ID
+	if instance.Name != "" {
+		name = fmt.Sprintf("%s (%s)", instance.Name, instance.ID)
+	}
+	value := fmt.Sprintf("**%s**", strings.ToUpper(instance.State))
+	if instance.PublicIP != "" {
+		value += fmt.Sprintf("\nIP: `%s`", instance.PublicIP)
+	}
+	value += fmt.Sprintf("\nType: `%s`", instance.InstanceType)
+	if uptime := instance.Uptime(); uptime > 0 {
+		value += fmt.Sprintf("\nUptime: %s", formatUptime(uptime))
+	}
+	if game := instance.Game; game != nil {
+		if game.Name != "" {
+			value += fmt.Sprintf("\nServer: %s", game.Name)
+		}
+		if game.Map != "" {
+			value += fmt.Sprintf("\nMap: `%s`", game.Map)
+		}
+		if game.Version != "" {
+			value += fmt.Sprintf("\nVersion: `%s`", game.Version)
+		}
+		value += fmt.Sprintf("\nPlayers: %s", formatPlayers(*game))
+		if names := game.PlayerNames; len(names) > 0 {
+			more := ""
+			if len(names) > maxListedPlayers {
+				more = fmt.Sprintf(" and %d more", len(names)-maxListedPlayers)
+				names = names[:maxListedPlayers]
+			}
+			value += fmt.Sprintf(" (%s%s)", strings.Join(names, ", "), more)
+		}
+	}
+
+	if r := []rune(value); len(r) > maxEmbedFieldLength {
+		value = string(r[:maxEmbedFieldLength-1]) + "…"
+	}
+	return &discordgo.MessageEmbedField{
+		Name:   stateEmoji(instance.State) + " " + name,
+		Value:  value,
+		Inline: true,
+	}
+}
+
+func renderJSON(region string, instances []Instance, _ int) statusView {
+	type instanceJSON struct {
+		Instance
+		Uptime string `json:"uptime"`
+	}
+	rows := make([]instanceJSON, 0, len(instances))
+	for _, instance := range instances {
+		rows = append(rows, instanceJSON{Instance: instance, Uptime: formatUptime(instance.Uptime())})
+	}
+
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return statusView{Content: fmt.Sprintf("Something went wrong...\n```%s```", err)}
+	}
+
+	content := fmt.Sprintf("```json\n%s\n```", data)
+	if len(content) <= maxMessageLength {
+		return statusView{Content: content}
+	}
+
+	// Too long for a message, send it as an attachment instead.
+	return statusView{
+		Content: fmt.Sprintf("Status - %s", region),
+		Files: []*discordgo.File{
+			{
+				Name:        fmt.Sprintf("status-%s.json", region),
+				ContentType: "application/json",
+				Reader:      strings.NewReader(string(data)),
+			},
+		},
+	}
+}
+
+func formatUptime(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh", days, hours)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
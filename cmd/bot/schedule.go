@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	// Schedules name IANA timezones, which may not be installed on the host.
+	_ "time/tzdata"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/robfig/cron/v3"
+)
+
+// Actions a schedule can run.
+const (
+	scheduleStart = "start"
+	scheduleStop  = "stop"
+)
+
+var scheduleActionList = [...]string{scheduleStart, scheduleStop}
+
+func getScheduleActionChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+
+	for _, a := range scheduleActionList {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  a,
+			Value: a,
+		})
+	}
+	return choices
+}
+
+const (
+	// How often the scheduler looks for due schedules.
+	scheduleCheckInterval = 30 * time.Second
+	// Runs missed by more than this, e.g. while the bot was down, are skipped
+	// instead of firing late.
+	scheduleMissedGrace = 5 * time.Minute
+	defaultTimezone     = "UTC"
+)
+
+// schedule is a cron rule starting or stopping an instance.
+type schedule struct {
+	ID         string
+	GuildID    string
+	Region     string
+	InstanceID string
+	Action     string
+	Cron       string
+	Timezone   string
+	ChannelID  string
+	CreatedBy  string
+	// NextRunAt is the unix time the schedule runs next.
+	NextRunAt int64
+}
+
+func newScheduleFromRow(row map[string]interface{}) schedule {
+	nextRunAt, _ := strconv.ParseInt(row["next_run_at"].(string), 10, 64)
+	return schedule{
+		ID:         row["id"].(string),
+		GuildID:    row["guild_id"].(string),
+		Region:     row["region"].(string),
+		InstanceID: row["instance_id"].(string),
+		Action:     row["action"].(string),
+		Cron:       row["cron"].(string),
+		Timezone:   row["timezone"].(string),
+		ChannelID:  row["channel_id"].(string),
+		CreatedBy:  row["created_by"].(string),
+		NextRunAt:  nextRunAt,
+	}
+}
+
+// parseSchedule parses a standard five field cron expression, like
+// `0 19 * * FRI`, evaluated in timezone.
+func parseSchedule(expr string, timezone string) (cron.Schedule, error) {
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, fmt.Errorf("unknown timezone `%s`, expected an IANA name like `Europe/Berlin`", timezone)
+	}
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		return nil, fmt.Errorf("give the timezone with the `timezone` option instead")
+	}
+	spec, err := cron.ParseStandard("CRON_TZ=" + timezone + " " + expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression `%s`: %w", expr, err)
+	}
+	return spec, nil
+}
+
+// runScheduler runs every schedule when it is due.
+func runScheduler(s *discordgo.Session) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		runDueSchedules(s)
+	}
+}
+
+func runDueSchedules(s *discordgo.Session) {
+	rows, err := queryDB("schedules", nil)
+	if err != nil {
+		log.Println("Error reading schedules:", err)
+		return
+	}
+	now := time.Now()
+	for _, row := range rows {
+		sc := newScheduleFromRow(row)
+		if sc.NextRunAt > now.Unix() {
+			continue
+		}
+		if err := runSchedule(s, sc, now); err != nil {
+			log.Printf("Error running schedule %s: %v", sc.ID, err)
+		}
+	}
+}
+
+func runSchedule(s *discordgo.Session, sc schedule, now time.Time) error {
+	spec, err := parseSchedule(sc.Cron, sc.Timezone)
+	if err != nil {
+		return err
+	}
+
+	claim := map[string]interface{}{
+		"id":          sc.ID,
+		"next_run_at": sc.NextRunAt,
+	}
+
+	// A rule that can never match again, like `0 0 30 2 *`, has no next run.
+	// It would be due on every tick, so it is removed instead.
+	next := spec.Next(now)
+	if next.IsZero() {
+		n, err := store.Delete(context.TODO(), "schedules", claim)
+		if err != nil || n == 0 {
+			return err
+		}
+		postScheduleOutcome(s, sc, fmt.Sprintf("Removed schedule `#%s` to %s `%s` in `%s`, `%s` never matches again.", sc.ID, sc.Action, sc.InstanceID, sc.Region, sc.Cron))
+		return nil
+	}
+
+	// Claim the run by moving next_run_at on, unless someone else already
+	// did. A run fires at most once, even across restarts.
+	n, err := store.Update(context.TODO(), "schedules", claim, map[string]interface{}{
+		"next_run_at": next.Unix(),
+	})
+	if err != nil || n == 0 {
+		return err
+	}
+
+	if now.Sub(time.Unix(sc.NextRunAt, 0)) > scheduleMissedGrace {
+		postScheduleOutcome(s, sc, fmt.Sprintf("Skipped the scheduled %s of `%s` in `%s` that was due <t:%d:R>, ValBot was not running.", sc.Action, sc.InstanceID, sc.Region, sc.NextRunAt))
+		return nil
+	}
+
+	event := &auditEvent{
+		GuildID:    sc.GuildID,
+		UserID:     s.State.User.ID,
+		Command:    "schedule-" + sc.Action,
+		Region:     sc.Region,
+		InstanceID: sc.InstanceID,
+		Outcome:    outcomeSuccess,
+	}
+	provider, err := getGuildProvider(sc.GuildID, sc.Region)
+	var change StateChange
+	if err == nil {
+		switch sc.Action {
+		case scheduleStart:
+			change, err = provider.Start(context.TODO(), sc.InstanceID)
+		case scheduleStop:
+			change, err = provider.Stop(context.TODO(), sc.InstanceID, false)
+		default:
+			err = fmt.Errorf("unknown schedule action `%s`", sc.Action)
+		}
+	}
+
+	var content string
+	if err != nil {
+		event.Outcome = outcomeFailure
+		event.Error = err.Error()
+		content = fmt.Sprintf("Scheduled %s of `%s` in `%s` failed...\n```%s```", sc.Action, sc.InstanceID, sc.Region, err)
+	} else {
+		autocompleteCache.invalidate(sc.GuildID, sc.Region)
+		content = fmt.Sprintf("Scheduled %s of `%s` in `%s`: %s", sc.Action, sc.InstanceID, sc.Region, change)
+	}
+	saveAudit(s, event)
+	postScheduleOutcome(s, sc, content)
+	return nil
+}
+
+func postScheduleOutcome(s *discordgo.Session, sc schedule, content string) {
+	if sc.ChannelID == "" {
+		log.Println(content)
+		return
+	}
+	if _, err := s.ChannelMessageSend(sc.ChannelID, content); err != nil {
+		log.Println("Error posting schedule outcome:", err)
+	}
+}
+
+// scheduleSubcommands implements /schedule add|remove|list.
+var scheduleSubcommands = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string){
+	"add": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		timezone := args["timezone"]
+		if timezone == "" {
+			timezone = defaultTimezone
+		}
+		spec, err := parseSchedule(args["cron"], timezone)
+		if err != nil {
+			sendMessageEphemeral(s, i, fmt.Sprintf("Invalid schedule: %s", err))
+			return
+		}
+		next := spec.Next(time.Now())
+		if next.IsZero() {
+			sendMessageEphemeral(s, i, fmt.Sprintf("Invalid schedule: `%s` never matches", args["cron"]))
+			return
+		}
+		channelID := args["channel"]
+		if channelID == "" {
+			channelID = i.ChannelID
+		}
+
+		provider, err := getProvider(args)
+		var instanceID string
+		if err == nil {
+			instanceID, err = resolveInstance(provider, args)
+		}
+		if err == nil {
+			auditInstance(i, instanceID)
+			err = insertDB("schedules", map[string]interface{}{
+				"guild_id":    args["guild_id"],
+				"region":      args["region"],
+				"instance_id": instanceID,
+				"action":      args["action"],
+				"cron":        args["cron"],
+				"timezone":    timezone,
+				"channel_id":  channelID,
+				"created_by":  i.Member.User.ID,
+				"next_run_at": next.Unix(),
+			})
+		}
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Scheduled %s of `%s` in `%s` at `%s` (%s), next <t:%d:F>. Outcomes are posted in <#%s>.", args["action"], instanceID, args["region"], args["cron"], timezone, next.Unix(), channelID))
+	},
+	"remove": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		n, err := deleteDB("schedules", map[string]interface{}{
+			"id":       args["id"],
+			"guild_id": args["guild_id"],
+		})
+		switch {
+		case err != nil:
+			sendError(s, i, err)
+		case n == 0:
+			sendMessageEphemeral(s, i, fmt.Sprintf("No schedule `#%s`", args["id"]))
+		default:
+			sendMessageEphemeral(s, i, fmt.Sprintf("Removed schedule `#%s`", args["id"]))
+		}
+	},
+	"list": func(s *discordgo.Session, i *discordgo.InteractionCreate, args map[string]string) {
+		rows, err := queryDB("schedules", map[string]interface{}{
+			"guild_id": args["guild_id"],
+			"region":   args["region"],
+		})
+		if err != nil {
+			sendError(s, i, err)
+			return
+		}
+		if len(rows) == 0 {
+			sendMessageEphemeral(s, i, fmt.Sprintf("No schedules in `%s`, add one with `/schedule add`", args["region"]))
+			return
+		}
+		schedules := make([]schedule, 0, len(rows))
+		for _, row := range rows {
+			schedules = append(schedules, newScheduleFromRow(row))
+		}
+		sort.Slice(schedules, func(a, b int) bool {
+			return schedules[a].NextRunAt < schedules[b].NextRunAt
+		})
+		var lines []string
+		for _, sc := range schedules {
+			lines = append(lines, fmt.Sprintf("`#%s` %s `%s` at `%s` (%s), next <t:%d:R>", sc.ID, sc.Action, sc.InstanceID, sc.Cron, sc.Timezone, sc.NextRunAt))
+		}
+		sendMessageEphemeral(s, i, fmt.Sprintf("Schedules in `%s`:\n%s", args["region"], strings.Join(lines, "\n")))
+	},
+}
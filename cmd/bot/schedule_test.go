@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr     string
+		timezone string
+		wantErr  bool
+	}{
+		{expr: "0 19 * * FRI", timezone: "Europe/Berlin"},
+		{expr: "*/5 * * * *", timezone: "UTC"},
+		{expr: "0 19 * *", timezone: "UTC", wantErr: true},
+		{expr: "0 19 * * *", timezone: "Mars/Base", wantErr: true},
+		{expr: "CRON_TZ=UTC 0 19 * * *", timezone: "UTC", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := parseSchedule(tt.expr, tt.timezone)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSchedule(%q, %q) error = %v, want error %v", tt.expr, tt.timezone, err, tt.wantErr)
+		}
+	}
+
+	spec, err := parseSchedule("0 19 * * FRI", "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	next := spec.Next(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)).In(berlin)
+	if next.Weekday() != time.Friday || next.Hour() != 19 || next.Minute() != 0 {
+		t.Errorf("Next() = %v, want Friday 19:00 in Berlin", next)
+	}
+}
+
+// insertTestSchedule saves a schedule due at nextRunAt and returns it.
+func insertTestSchedule(t *testing.T, cron string, action string, nextRunAt time.Time) schedule {
+	t.Helper()
+	err := insertDB("schedules", map[string]interface{}{
+		"guild_id":    testGuildID,
+		"region":      testRegion,
+		"instance_id": "i-stopped",
+		"action":      action,
+		"cron":        cron,
+		"timezone":    "UTC",
+		"channel_id":  "channel",
+		"created_by":  testAdminID,
+		"next_run_at": nextRunAt.Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := queryDB("schedules", nil)
+	if err != nil || len(rows) == 0 {
+		t.Fatalf("schedules = %v, %v", rows, err)
+	}
+	return newScheduleFromRow(rows[len(rows)-1])
+}
+
+func TestScheduleAddRejectsNeverMatching(t *testing.T) {
+	useTestStore(t)
+	useMemoryProvider(t, newTestServers())
+	s, rt := newTestSession(t)
+
+	handleInteraction(s, commandInteraction("schedule", subcommand("add",
+		stringOption("region", testRegion),
+		stringOption("server", "i-stopped"),
+		stringOption("action", scheduleStart),
+		stringOption("cron", "0 0 30 2 *"),
+	)))
+
+	if got := rt.lastContent(); !strings.Contains(got, "never matches") {
+		t.Errorf("content = %q, want the schedule rejected", got)
+	}
+	rows, err := queryDB("schedules", nil)
+	if err != nil || len(rows) != 0 {
+		t.Errorf("schedules = %v, %v, want none saved", rows, err)
+	}
+}
+
+func TestRunScheduleRemovesNeverMatching(t *testing.T) {
+	useTestStore(t)
+	useMemoryProvider(t, newTestServers())
+	s, rt := newTestSession(t)
+	now := time.Now()
+	sc := insertTestSchedule(t, "0 0 30 2 *", scheduleStart, now.Add(-time.Minute))
+
+	if err := runSchedule(s, sc, now); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := queryDB("schedules", nil)
+	if err != nil || len(rows) != 0 {
+		t.Errorf("schedules = %v, %v, want it removed", rows, err)
+	}
+	if got := rt.lastContent(); !strings.Contains(got, "never matches again") {
+		t.Errorf("content = %q, want the removal posted", got)
+	}
+}
+
+func TestRunScheduleRunsOnce(t *testing.T) {
+	useTestStore(t)
+	p := newTestServers()
+	useMemoryProvider(t, p)
+	s, rt := newTestSession(t)
+	now := time.Now()
+	sc := insertTestSchedule(t, "*/5 * * * *", scheduleStart, now.Add(-time.Minute))
+
+	// A second runner with the same stale row must lose the claim.
+	for n := 0; n < 2; n++ {
+		if err := runSchedule(s, sc, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state := p.state(t, "i-stopped"); state != "running" {
+		t.Errorf("state = %s, want running", state)
+	}
+	var posted int
+	for _, m := range rt.messages() {
+		if content, _ := m["content"].(string); strings.HasPrefix(content, "Scheduled start") {
+			posted++
+		}
+	}
+	if posted != 1 {
+		t.Errorf("posted %d outcomes, want 1", posted)
+	}
+
+	rows, err := queryDB("schedules", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := newScheduleFromRow(rows[0]).NextRunAt; next <= now.Unix() {
+		t.Errorf("next_run_at = %d, want after %d", next, now.Unix())
+	}
+}
+
+func TestRunScheduleSkipsMissedRun(t *testing.T) {
+	useTestStore(t)
+	p := newTestServers()
+	useMemoryProvider(t, p)
+	s, rt := newTestSession(t)
+	now := time.Now()
+	sc := insertTestSchedule(t, "*/5 * * * *", scheduleStart, now.Add(-time.Hour))
+
+	if err := runSchedule(s, sc, now); err != nil {
+		t.Fatal(err)
+	}
+	if state := p.state(t, "i-stopped"); state != "stopped" {
+		t.Errorf("state = %s, want a missed run skipped", state)
+	}
+	if got := rt.lastContent(); !strings.Contains(got, "Skipped") {
+		t.Errorf("content = %q, want the skip posted", got)
+	}
+}
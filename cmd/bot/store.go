@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GuildStore persists everything ValBot keeps per guild. Rows are passed as
+// column → value maps and every value is read back as a string, with NULL
+// columns coming back empty.
+type GuildStore interface {
+	// Query returns the rows of table matching every column in where. An empty
+	// where returns the whole table.
+	Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error)
+	// Recent returns the last limit rows inserted into table matching where,
+	// newest first.
+	Recent(ctx context.Context, table string, where map[string]interface{}, limit int) ([]map[string]interface{}, error)
+	Insert(ctx context.Context, table string, values map[string]interface{}) error
+	// Update sets values on the rows of table matching where and returns how
+	// many rows changed, so it can be used as a compare-and-set.
+	Update(ctx context.Context, table string, where map[string]interface{}, values map[string]interface{}) (int64, error)
+	Delete(ctx context.Context, table string, where map[string]interface{}) (int64, error)
+	// Tx runs fn against a store whose changes are committed together when fn
+	// returns nil. Rows read inside fn are locked against other writers until then.
+	Tx(ctx context.Context, fn func(tx GuildStore) error) error
+
+	MigrateUp(ctx context.Context) (int, error)
+	MigrateDown(ctx context.Context, steps int) (int, error)
+	MigrationStatus(ctx context.Context) ([]migrationStatus, error)
+	Close() error
+}
+
+// stores maps the DATABASE_URL scheme to the GuildStore it selects.
+var stores = map[string]func(url string) (GuildStore, error){
+	"postgres":   newPostgresStore,
+	"postgresql": newPostgresStore,
+	"sqlite":     newSQLiteStore,
+}
+
+// openGuildStore opens the store selected by the scheme of url. Connection
+// strings without a scheme, like `host=... dbname=...`, are Postgres.
+func openGuildStore(url string) (GuildStore, error) {
+	scheme := "postgres"
+	if i := strings.Index(url, "://"); i >= 0 {
+		scheme = url[:i]
+	}
+	newStore, ok := stores[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database scheme `%s`", scheme)
+	}
+	return newStore(url)
+}
+
+// storeColumns lists the columns the store may name in SQL for each table.
+// Table and column names can't be bind parameters, so anything else is
+// rejected before it reaches a statement. Keep it in sync with the migrations.
+var storeColumns = map[string]map[string]bool{
+	"guilds": {
+		"id": true, "guild_id": true, "region": true, "provider": true, "auth_mode": true,
+		"aws_access_key_id": true, "aws_secret_access_key": true, "role_arn": true, "data_key": true,
+		"updated_by": true, "updated_at": true, "previous_auth_mode": true, "previous_aws_access_key_id": true,
+		"previous_aws_secret_access_key": true, "previous_role_arn": true,
+		"previous_data_key": true, "previous_updated_by": true, "previous_updated_at": true,
+	},
+	"guild_aliases": {
+		"id": true, "guild_id": true, "region": true, "alias": true, "instance_id": true,
+	},
+	"guild_servers": {
+		"id": true, "guild_id": true, "region": true, "kind": true, "value": true,
+	},
+	"guild_permissions": {
+		"id": true, "guild_id": true, "subject_type": true, "subject_id": true, "capability": true,
+	},
+	"audit_events": {
+		"id": true, "guild_id": true, "user_id": true, "command": true, "region": true,
+		"instance_id": true, "outcome": true, "error": true, "created_at": true,
+	},
+	"guild_settings": {
+		"id": true, "guild_id": true, "audit_channel_id": true, "external_id": true,
+	},
+	"server_settings": {
+		"id": true, "guild_id": true, "region": true, "instance_id": true, "query_type": true,
+		"query_port": true, "game_port": true, "idle_minutes": true, "channel_id": true,
+	},
+	"schedules": {
+		"id": true, "guild_id": true, "region": true, "instance_id": true, "action": true,
+		"cron": true, "timezone": true, "channel_id": true, "created_by": true, "next_run_at": true,
+	},
+}
+
+// checkColumns returns an error unless table and every column in each of args are allowlisted.
+func checkColumns(table string, args ...map[string]interface{}) error {
+	columns, ok := storeColumns[table]
+	if !ok {
+		return fmt.Errorf("unknown table `%s`", table)
+	}
+	for _, a := range args {
+		for k := range a {
+			if !columns[k] {
+				return fmt.Errorf("unknown column `%s` for table `%s`", k, table)
+			}
+		}
+	}
+	return nil
+}
+
+// sqlDialect holds what differs between the database/sql backed stores.
+type sqlDialect struct {
+	// placeholder returns the bind parameter for the nth argument, counting from 1.
+	placeholder func(n int) string
+	// lockRows is appended to queries run inside a transaction.
+	lockRows string
+	// migrationsDir is the directory of migrationFiles holding this dialect's migrations.
+	migrationsDir string
+	// createMigrationsTable creates schema_migrations if it doesn't exist.
+	createMigrationsTable string
+	// hasMigrationsTable selects whether schema_migrations exists.
+	hasMigrationsTable string
+	// lockMigrations keeps other bots from migrating until the returned
+	// function is called.
+	lockMigrations func(ctx context.Context, conn *sql.Conn) (func(), error)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlStore implements GuildStore on top of database/sql.
+type sqlStore struct {
+	db      *sql.DB
+	q       querier
+	inTx    bool
+	dialect *sqlDialect
+}
+
+func newSQLStore(db *sql.DB, dialect *sqlDialect) *sqlStore {
+	return &sqlStore{db: db, q: db, dialect: dialect}
+}
+
+// sortedKeys returns the columns of args in a stable order.
+func sortedKeys(args map[string]interface{}) []string {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// where builds the WHERE clause for args, numbering its parameters after
+// the first n already used by the statement.
+func (s *sqlStore) where(args map[string]interface{}, n int) (string, []interface{}) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	sqlWhere := make([]string, 0, len(args))
+	sqlArgs := make([]interface{}, 0, len(args))
+	for _, k := range sortedKeys(args) {
+		sqlArgs = append(sqlArgs, args[k])
+		sqlWhere = append(sqlWhere, fmt.Sprintf("%s = %s", k, s.dialect.placeholder(n+len(sqlArgs))))
+	}
+	return " WHERE " + strings.Join(sqlWhere, " AND "), sqlArgs
+}
+
+func (s *sqlStore) Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error) {
+	if err := checkColumns(table, where); err != nil {
+		return nil, err
+	}
+	sqlWhere, sqlArgs := s.where(where, 0)
+	sqlStatement := fmt.Sprintf("SELECT * FROM %s%s", table, sqlWhere)
+	if s.inTx {
+		sqlStatement += s.dialect.lockRows
+	}
+
+	return s.query(ctx, sqlStatement+";", sqlArgs...)
+}
+
+func (s *sqlStore) Recent(ctx context.Context, table string, where map[string]interface{}, limit int) ([]map[string]interface{}, error) {
+	if err := checkColumns(table, where); err != nil {
+		return nil, err
+	}
+	sqlWhere, sqlArgs := s.where(where, 0)
+	sqlArgs = append(sqlArgs, limit)
+	sqlStatement := fmt.Sprintf("SELECT * FROM %s%s ORDER BY id DESC LIMIT %s;", table, sqlWhere, s.dialect.placeholder(len(sqlArgs)))
+	return s.query(ctx, sqlStatement, sqlArgs...)
+}
+
+// query runs sqlStatement and reads every row into a column → value map.
+func (s *sqlStore) query(ctx context.Context, sqlStatement string, sqlArgs ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := s.q.QueryContext(ctx, sqlStatement, sqlArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []map[string]interface{}
+
+	for rows.Next() {
+		entry := make(map[string]interface{})
+		columns := make([]sql.NullString, len(cols))
+		columnPointers := make([]interface{}, len(cols))
+		for i := range columns {
+			columnPointers[i] = &columns[i]
+		}
+
+		if err := rows.Scan(columnPointers...); err != nil {
+			return nil, err
+		}
+
+		// NULL columns come back as empty strings.
+		for i, colName := range cols {
+			entry[colName] = columns[i].String
+		}
+		data = append(data, entry)
+	}
+
+	return data, rows.Err()
+}
+
+func (s *sqlStore) Insert(ctx context.Context, table string, values map[string]interface{}) error {
+	if err := checkColumns(table, values); err != nil {
+		return err
+	}
+	sqlCols := make([]string, 0, len(values))
+	sqlVals := make([]string, 0, len(values))
+	sqlArgs := make([]interface{}, 0, len(values))
+
+	for _, k := range sortedKeys(values) {
+		sqlArgs = append(sqlArgs, values[k])
+		sqlCols = append(sqlCols, k)
+		sqlVals = append(sqlVals, s.dialect.placeholder(len(sqlArgs)))
+	}
+
+	sqlStatement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id;", table, strings.Join(sqlCols, ", "), strings.Join(sqlVals, ", "))
+
+	var id int
+	return s.q.QueryRowContext(ctx, sqlStatement, sqlArgs...).Scan(&id)
+}
+
+func (s *sqlStore) Update(ctx context.Context, table string, where map[string]interface{}, values map[string]interface{}) (int64, error) {
+	if err := checkColumns(table, where, values); err != nil {
+		return 0, err
+	}
+	sqlSets := make([]string, 0, len(values))
+	sqlArgs := make([]interface{}, 0, len(values)+len(where))
+
+	for _, k := range sortedKeys(values) {
+		sqlArgs = append(sqlArgs, values[k])
+		sqlSets = append(sqlSets, fmt.Sprintf("%s = %s", k, s.dialect.placeholder(len(sqlArgs))))
+	}
+	sqlWhere, whereArgs := s.where(where, len(sqlArgs))
+	sqlArgs = append(sqlArgs, whereArgs...)
+
+	sqlStatement := fmt.Sprintf("UPDATE %s SET %s%s;", table, strings.Join(sqlSets, ", "), sqlWhere)
+
+	res, err := s.q.ExecContext(ctx, sqlStatement, sqlArgs...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) Delete(ctx context.Context, table string, where map[string]interface{}) (int64, error) {
+	if err := checkColumns(table, where); err != nil {
+		return 0, err
+	}
+	sqlWhere, sqlArgs := s.where(where, 0)
+	sqlStatement := fmt.Sprintf("DELETE FROM %s%s;", table, sqlWhere)
+
+	res, err := s.q.ExecContext(ctx, sqlStatement, sqlArgs...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) Tx(ctx context.Context, fn func(tx GuildStore) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqlStore{db: s.db, q: tx, inTx: true, dialect: s.dialect}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	_ "github.com/lib/pq"
+)
+
+// migrationLockID is the Postgres advisory lock held while migrating, so
+// replicas starting at the same time don't apply the same migration twice.
+const migrationLockID = 0x76626f74 // "vbot"
+
+var postgresDialect = &sqlDialect{
+	placeholder: func(n int) string {
+		return "$" + strconv.Itoa(n)
+	},
+	lockRows:      " FOR UPDATE",
+	migrationsDir: "migrations/postgres",
+	createMigrationsTable: `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	);`,
+	hasMigrationsTable: "SELECT to_regclass('schema_migrations') IS NOT NULL;",
+	lockMigrations: func(ctx context.Context, conn *sql.Conn) (func(), error) {
+		// Advisory locks belong to the session, so lock and unlock on the same connection.
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", migrationLockID); err != nil {
+			return nil, err
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1);", migrationLockID)
+		}, nil
+	},
+}
+
+func newPostgresStore(url string) (GuildStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newSQLStore(db, postgresDialect), nil
+}
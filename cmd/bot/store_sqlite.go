@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrateMu serializes migrations. A SQLite file is only meant to be
+// used by a single bot, so a lock within the process is enough.
+var sqliteMigrateMu sync.Mutex
+
+var sqliteDialect = &sqlDialect{
+	placeholder: func(n int) string {
+		return "?"
+	},
+	// Transactions begin IMMEDIATE, which already locks the database for writes.
+	lockRows:      "",
+	migrationsDir: "migrations/sqlite",
+	createMigrationsTable: `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);`,
+	hasMigrationsTable: "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations';",
+	lockMigrations: func(ctx context.Context, conn *sql.Conn) (func(), error) {
+		sqliteMigrateMu.Lock()
+		return sqliteMigrateMu.Unlock, nil
+	},
+}
+
+// newSQLiteStore opens the SQLite file at url, given as sqlite://<path>, e.g.
+// sqlite://valbot.db or sqlite:///var/lib/valbot/valbot.db. Query parameters
+// are passed on to the driver.
+func newSQLiteStore(url string) (GuildStore, error) {
+	dsn := strings.TrimPrefix(url, "sqlite://")
+	if strings.Contains(dsn, "?") {
+		dsn += "&"
+	} else {
+		dsn += "?"
+	}
+	dsn += "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, and in-memory databases only live as
+	// long as their connection.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newSQLStore(db, sqliteDialect), nil
+}
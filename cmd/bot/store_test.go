@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forEachStore runs fn against every GuildStore implementation, each with an
+// empty database. Postgres is only tested when DATABASE_URL is set, in a
+// schema of its own that is dropped afterwards.
+func forEachStore(t *testing.T, fn func(t *testing.T, st GuildStore)) {
+	t.Run("sqlite", func(t *testing.T) {
+		st, err := openGuildStore("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		fn(t, st)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("DATABASE_URL")
+		if url == "" {
+			t.Skip("DATABASE_URL is not set")
+		}
+		fn(t, openPostgresTestStore(t, url))
+	})
+}
+
+// openPostgresTestStore opens the database at url with a new schema first in
+// the search path, so the test neither sees nor changes existing tables.
+func openPostgresTestStore(t *testing.T, url string) GuildStore {
+	t.Helper()
+	admin, err := newPostgresStore(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := admin.(*sqlStore).db
+	schema := fmt.Sprintf("valbot_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	// lib/pq passes unknown parameters on as run-time parameters.
+	switch {
+	case !strings.Contains(url, "://"):
+		url += " search_path=" + schema
+	case strings.Contains(url, "?"):
+		url += "&search_path=" + schema
+	default:
+		url += "?search_path=" + schema
+	}
+	st, err := newPostgresStore(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func migrateTestStore(t *testing.T, st GuildStore) {
+	t.Helper()
+	if _, err := st.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreInsertQuery(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st GuildStore) {
+		migrateTestStore(t, st)
+		ctx := context.Background()
+		for _, alias := range []string{"vh", "mc"} {
+			err := st.Insert(ctx, "guild_aliases", map[string]interface{}{
+				"guild_id":    testGuildID,
+				"region":      testRegion,
+				"alias":       alias,
+				"instance_id": "i-" + alias,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		all, err := st.Query(ctx, "guild_aliases", nil)
+		if err != nil || len(all) != 2 {
+			t.Fatalf("Query() = %v, %v, want 2 rows", all, err)
+		}
+		rows, err := st.Query(ctx, "guild_aliases", map[string]interface{}{"guild_id": testGuildID, "alias": "mc"})
+		if err != nil || len(rows) != 1 || rows[0]["instance_id"] != "i-mc" {
+			t.Fatalf("Query(mc) = %v, %v, want i-mc", rows, err)
+		}
+		if id, ok := rows[0]["id"].(string); !ok || id == "" {
+			t.Errorf("id = %#v, want a generated id", rows[0]["id"])
+		}
+		if rows, err := st.Query(ctx, "guild_aliases", map[string]interface{}{"alias": "none"}); err != nil || len(rows) != 0 {
+			t.Errorf("Query(none) = %v, %v, want no rows", rows, err)
+		}
+
+		// Every value is read back as a string, NULL as an empty one.
+		err = st.Insert(ctx, "server_settings", map[string]interface{}{
+			"guild_id":    testGuildID,
+			"region":      testRegion,
+			"instance_id": "i-vh",
+			"query_type":  "a2s",
+			"query_port":  2457,
+			"channel_id":  nil,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err = st.Query(ctx, "server_settings", map[string]interface{}{"query_port": 2457})
+		if err != nil || len(rows) != 1 {
+			t.Fatalf("Query(server_settings) = %v, %v", rows, err)
+		}
+		if rows[0]["query_port"] != "2457" || rows[0]["idle_minutes"] != "0" || rows[0]["channel_id"] != "" {
+			t.Errorf("server_settings = %v, want string values", rows[0])
+		}
+	})
+}
+
+func TestStoreRecent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st GuildStore) {
+		migrateTestStore(t, st)
+		ctx := context.Background()
+		for n := 0; n < 5; n++ {
+			err := st.Insert(ctx, "audit_events", map[string]interface{}{
+				"guild_id":   testGuildID,
+				"user_id":    testAdminID,
+				"command":    "start",
+				"outcome":    []string{outcomeSuccess, outcomeFailure}[n%2],
+				"created_at": time.Now().UTC(),
+				"error":      strconv.Itoa(n),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rows, err := st.Recent(ctx, "audit_events", map[string]interface{}{"guild_id": testGuildID}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, row := range rows {
+			got = append(got, row["error"].(string))
+		}
+		if strings.Join(got, ",") != "4,3,2" {
+			t.Errorf("Recent(3) = %v, want 4,3,2", got)
+		}
+
+		rows, err = st.Recent(ctx, "audit_events", map[string]interface{}{"outcome": outcomeFailure}, 10)
+		if err != nil || len(rows) != 2 || rows[0]["error"] != "3" {
+			t.Errorf("Recent(failure) = %v, %v, want events 3 and 1", rows, err)
+		}
+	})
+}
+
+func TestStoreUpdateDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st GuildStore) {
+		migrateTestStore(t, st)
+		ctx := context.Background()
+		for _, id := range []string{"i-1", "i-2", "i-3"} {
+			err := st.Insert(ctx, "guild_servers", map[string]interface{}{
+				"guild_id": testGuildID,
+				"region":   testRegion,
+				"kind":     serverRuleID,
+				"value":    id,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := st.Update(ctx, "guild_servers", map[string]interface{}{"value": "i-1"}, map[string]interface{}{"value": "i-4"})
+		if err != nil || n != 1 {
+			t.Errorf("Update(i-1) = %d, %v, want 1", n, err)
+		}
+		// The row no longer matches, which is what makes Update a compare-and-set.
+		n, err = st.Update(ctx, "guild_servers", map[string]interface{}{"value": "i-1"}, map[string]interface{}{"value": "i-5"})
+		if err != nil || n != 0 {
+			t.Errorf("Update(i-1) again = %d, %v, want 0", n, err)
+		}
+		n, err = st.Update(ctx, "guild_servers", map[string]interface{}{"guild_id": testGuildID}, map[string]interface{}{"region": "us-west-2"})
+		if err != nil || n != 3 {
+			t.Errorf("Update(guild) = %d, %v, want 3", n, err)
+		}
+
+		n, err = st.Delete(ctx, "guild_servers", map[string]interface{}{"value": "i-4"})
+		if err != nil || n != 1 {
+			t.Errorf("Delete(i-4) = %d, %v, want 1", n, err)
+		}
+		n, err = st.Delete(ctx, "guild_servers", map[string]interface{}{"value": "i-4"})
+		if err != nil || n != 0 {
+			t.Errorf("Delete(i-4) again = %d, %v, want 0", n, err)
+		}
+		n, err = st.Delete(ctx, "guild_servers", map[string]interface{}{"region": "us-west-2"})
+		if err != nil || n != 2 {
+			t.Errorf("Delete(region) = %d, %v, want 2", n, err)
+		}
+	})
+}
+
+func TestStoreTx(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st GuildStore) {
+		migrateTestStore(t, st)
+		ctx := context.Background()
+		insert := func(s GuildStore, capability string) error {
+			return s.Insert(ctx, "guild_permissions", map[string]interface{}{
+				"guild_id":     testGuildID,
+				"subject_type": subjectUser,
+				"subject_id":   testMemberID,
+				"capability":   capability,
+			})
+		}
+
+		err := st.Tx(ctx, func(tx GuildStore) error {
+			if err := insert(tx, capabilityView); err != nil {
+				return err
+			}
+			// A nested Tx joins the outer one.
+			return tx.Tx(ctx, func(tx GuildStore) error {
+				return insert(tx, capabilityPower)
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errRollback := errors.New("rollback")
+		err = st.Tx(ctx, func(tx GuildStore) error {
+			if err := insert(tx, capabilityAdmin); err != nil {
+				return err
+			}
+			if rows, err := tx.Query(ctx, "guild_permissions", nil); err != nil || len(rows) != 3 {
+				t.Errorf("Query() in Tx = %v, %v, want 3 rows", rows, err)
+			}
+			return errRollback
+		})
+		if err != errRollback {
+			t.Errorf("Tx() = %v, want the error of fn", err)
+		}
+
+		rows, err := st.Query(ctx, "guild_permissions", nil)
+		if err != nil || len(rows) != 2 {
+			t.Errorf("Query() = %v, %v, want the committed rows only", rows, err)
+		}
+	})
+}
+
+func TestStoreMigrate(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st GuildStore) {
+		ctx := context.Background()
+		status, err := st.MigrationStatus(ctx)
+		if err != nil || len(status) == 0 {
+			t.Fatalf("MigrationStatus() = %v, %v", status, err)
+		}
+		total := len(status)
+		for _, m := range status {
+			if m.Applied {
+				t.Errorf("migration %d applied in an empty database", m.Version)
+			}
+		}
+
+		if n, err := st.MigrateUp(ctx); err != nil || n != total {
+			t.Fatalf("MigrateUp() = %d, %v, want %d", n, err, total)
+		}
+		if n, err := st.MigrateUp(ctx); err != nil || n != 0 {
+			t.Errorf("MigrateUp() again = %d, %v, want 0", n, err)
+		}
+		status, err = st.MigrationStatus(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range status {
+			if !m.Applied || m.AppliedAt.IsZero() {
+				t.Errorf("migration %d = %+v, want it applied", m.Version, m)
+			}
+		}
+
+		if n, err := st.MigrateDown(ctx, 1); err != nil || n != 1 {
+			t.Errorf("MigrateDown(1) = %d, %v, want 1", n, err)
+		}
+		status, err = st.MigrationStatus(ctx)
+		if err != nil || status[total-1].Applied || !status[total-2].Applied {
+			t.Errorf("MigrationStatus() = %v, %v, want only the latest rolled back", status, err)
+		}
+
+		if n, err := st.MigrateDown(ctx, total); err != nil || n != total-1 {
+			t.Errorf("MigrateDown(all) = %d, %v, want %d", n, err, total-1)
+		}
+		if _, err := st.Query(ctx, "guilds", nil); err == nil {
+			t.Error("Query(guilds) succeeded after rolling back every migration")
+		}
+		if n, err := st.MigrateUp(ctx); err != nil || n != total {
+			t.Errorf("MigrateUp() after rolling back = %d, %v, want %d", n, err, total)
+		}
+	})
+}
+
+func TestStoreMigrationStatusReadOnly(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st GuildStore) {
+		ctx := context.Background()
+		s := st.(*sqlStore)
+		hasTable := func() bool {
+			t.Helper()
+			var exists bool
+			if err := s.db.QueryRowContext(ctx, s.dialect.hasMigrationsTable).Scan(&exists); err != nil {
+				t.Fatal(err)
+			}
+			return exists
+		}
+
+		if _, err := st.MigrationStatus(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if hasTable() {
+			t.Error("MigrationStatus() created schema_migrations")
+		}
+
+		// A status taken while a migration runs doesn't wait for it. The
+		// SQLite lock is a mutex, and its single connection must stay free.
+		var conn *sql.Conn
+		if s.dialect == postgresDialect {
+			var err error
+			conn, err = s.db.Conn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+		}
+		unlock, err := s.dialect.lockMigrations(ctx, conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unlock()
+		status, err := st.MigrationStatus(ctx)
+		if err != nil || len(status) == 0 || status[0].Applied {
+			t.Errorf("MigrationStatus() under the lock = %v, %v, want every migration pending", status, err)
+		}
+	})
+}
+
+func TestStoreRejectsUnknownColumns(t *testing.T) {
+	forEachStore(t, func(t *testing.T, st GuildStore) {
+		migrateTestStore(t, st)
+		ctx := context.Background()
+		valid := map[string]interface{}{"guild_id": testGuildID}
+		err := st.Insert(ctx, "guild_settings", valid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for table, bad := range map[string]map[string]interface{}{
+			"guild_settings":             {"x; drop table guild_settings; --": 1},
+			"guilds":                     {"guild_id = guild_id OR 1": 1},
+			"guild_settings; drop table": {"guild_id": testGuildID},
+			"schema_migrations":          {"version": 1},
+		} {
+			operations := map[string]func() error{
+				"Query": func() error {
+					_, err := st.Query(ctx, table, bad)
+					return err
+				},
+				"Recent": func() error {
+					_, err := st.Recent(ctx, table, bad, 1)
+					return err
+				},
+				"Insert": func() error {
+					return st.Insert(ctx, table, bad)
+				},
+				"Update where": func() error {
+					_, err := st.Update(ctx, table, bad, valid)
+					return err
+				},
+				"Update values": func() error {
+					_, err := st.Update(ctx, table, valid, bad)
+					return err
+				},
+				"Delete": func() error {
+					_, err := st.Delete(ctx, table, bad)
+					return err
+				},
+			}
+			for name, op := range operations {
+				if err := op(); err == nil || !strings.Contains(err.Error(), "unknown") {
+					t.Errorf("%s(%q, %v) error = %v, want it rejected", name, table, bad, err)
+				}
+			}
+		}
+
+		if rows, err := st.Query(ctx, "guild_settings", valid); err != nil || len(rows) != 1 {
+			t.Errorf("guild_settings = %v, %v, want it untouched", rows, err)
+		}
+	})
+}
+
+func TestCheckColumns(t *testing.T) {
+	tests := []struct {
+		table   string
+		args    []map[string]interface{}
+		wantErr string
+	}{
+		{table: "guilds", args: []map[string]interface{}{{"guild_id": 1}, {"region": 1, "role_arn": 1}}},
+		{table: "guilds", args: []map[string]interface{}{nil}},
+		{table: "guilds", args: []map[string]interface{}{{"guild_id": 1}, {"x; drop": 1}}, wantErr: "unknown column `x; drop` for table `guilds`"},
+		{table: "guilds", args: []map[string]interface{}{{"GUILD_ID": 1}}, wantErr: "unknown column"},
+		{table: "users", args: []map[string]interface{}{{"guild_id": 1}}, wantErr: "unknown table `users`"},
+		{table: "", wantErr: "unknown table"},
+	}
+	for _, tt := range tests {
+		err := checkColumns(tt.table, tt.args...)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("checkColumns(%q, %v) = %v, want nil", tt.table, tt.args, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("checkColumns(%q, %v) = %v, want %q", tt.table, tt.args, err, tt.wantErr)
+		}
+	}
+}
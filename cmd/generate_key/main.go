@@ -3,15 +3,23 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"flag"
 	"fmt"
 )
 
+var keyID = flag.String("id", "", "Key ID to prefix the key with, for adding it to an existing VBOT_AES_KEY keyring")
+
 func main() {
+	flag.Parse()
+
 	bytes := make([]byte, 32) //generate a random 32 byte key for AES-256
 	if _, err := rand.Read(bytes); err != nil {
 		panic(err.Error())
 	}
 
 	key := hex.EncodeToString(bytes) //encode key in bytes to string and keep as secret, put in a vault
+	if *keyID != "" {
+		key = *keyID + ":" + key
+	}
 	fmt.Printf("AES key to encrypt/decrypt : %s\n", key)
 }